@@ -1,24 +1,31 @@
 package mp4
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math"
+	"time"
 )
 
 const (
-	BoxHeaderSize = 8
+	BoxHeaderSize      = 8
+	LargeBoxHeaderSize = 16
 )
 
 var (
 	ErrUnknownBoxType  = errors.New("unknown box type")
 	ErrTruncatedHeader = errors.New("truncated header")
 	ErrBadFormat       = errors.New("bad format")
+	ErrUnknownSize     = errors.New("unknown box size")
 )
 
-var decoders map[string]BoxDecoder
+var (
+	decoders     map[string]BoxDecoder
+	uuidDecoders = map[[16]byte]BoxDecoder{}
+)
 
 func init() {
 	decoders = map[string]BoxDecoder{
@@ -41,8 +48,10 @@ func init() {
 		"dref": DecodeDref,
 		"stbl": DecodeStbl,
 		"stco": DecodeStco,
+		"co64": DecodeCo64,
 		"stsc": DecodeStsc,
 		"stsz": DecodeStsz,
+		"stz2": DecodeStz2,
 		"ctts": DecodeCtts,
 		"stsd": DecodeStsd,
 		"stts": DecodeStts,
@@ -52,28 +61,103 @@ func init() {
 	}
 }
 
+// A box header
+//
+// Size is the size of the whole box, header included. A size of 0 means that the box extends to the end of the file.
+// HeaderSize is either BoxHeaderSize or LargeBoxHeaderSize (when the 64 bits "largesize" field is used).
 type BoxHeader struct {
-	Type string
-	Size uint32
+	Type       string
+	Size       uint64
+	HeaderSize uint64
 }
 
-// Decode a box header (size + box type)
+// Decode a box header (size + box type + optional 64 bits size)
 func DecodeHeader(r io.Reader) (BoxHeader, error) {
-	buf := make([]byte, BoxHeaderSize)
-	n, err := r.Read(buf)
+	buf := make([]byte, LargeBoxHeaderSize)
+	_, err := io.ReadFull(r, buf[:BoxHeaderSize])
+	if err == io.ErrUnexpectedEOF {
+		return BoxHeader{}, ErrTruncatedHeader
+	}
 	if err != nil {
 		return BoxHeader{}, err
 	}
-	if n != BoxHeaderSize {
-		return BoxHeader{}, ErrTruncatedHeader
+	h := BoxHeader{
+		Type:       string(buf[4:8]),
+		Size:       uint64(binary.BigEndian.Uint32(buf[0:4])),
+		HeaderSize: BoxHeaderSize,
+	}
+	if h.Size == 1 {
+		_, err = io.ReadFull(r, buf[BoxHeaderSize:])
+		if err != nil {
+			return BoxHeader{}, ErrTruncatedHeader
+		}
+		h.Size = binary.BigEndian.Uint64(buf[BoxHeaderSize:])
+		h.HeaderSize = LargeBoxHeaderSize
+	}
+	if h.Size != 0 && (h.Size < h.HeaderSize || h.Size > math.MaxInt64) {
+		return BoxHeader{}, ErrBadFormat
+	}
+	return h, nil
+}
+
+// largeHeaderBox is implemented by boxes that keep the large header they were decoded with
+type largeHeaderBox interface {
+	largeHeader() bool
+	setLargeHeader()
+}
+
+// header is embedded in boxes to remember the size of the header they were decoded with, so that they are
+// encoded back with the same header
+type header struct {
+	large bool
+}
+
+func (h *header) largeHeader() bool {
+	return h.large
+}
+
+func (h *header) setLargeHeader() {
+	h.large = true
+}
+
+// boxSize returns the size of a box with the given content size, header included
+func (h *header) boxSize(contentSize uint64) uint64 {
+	if h.large {
+		return LargeBoxHeaderSize + contentSize
+	}
+	return boxSize(contentSize)
+}
+
+// headerSize returns the size of the header of b, as written by EncodeHeader
+func headerSize(b Box) uint64 {
+	if l, ok := b.(largeHeaderBox); ok && l.largeHeader() || b.Size() > math.MaxUint32 {
+		return LargeBoxHeaderSize
 	}
-	return BoxHeader{string(buf[4:8]), binary.BigEndian.Uint32(buf[0:4])}, nil
+	return BoxHeaderSize
 }
 
-// Encode a box header
+// toEOFBox is implemented by boxes that may extend to the end of the file (size 0 header)
+type toEOFBox interface {
+	toEOF() bool
+}
+
+// Encode a box header. The 64 bits "largesize" form is used when the box size does not fit in 32 bits,
+// or when the box was decoded with a large header. Boxes decoded with a size of 0 (extending to the end
+// of the file) keep it.
 func EncodeHeader(b Box, w io.Writer) error {
-	buf := make([]byte, BoxHeaderSize)
-	binary.BigEndian.PutUint32(buf, uint32(b.Size()))
+	sz := b.Size()
+	if e, ok := b.(toEOFBox); ok && e.toEOF() {
+		sz = 0
+	}
+	var buf []byte
+	if headerSize(b) == LargeBoxHeaderSize {
+		buf = make([]byte, LargeBoxHeaderSize)
+		binary.BigEndian.PutUint32(buf, 1)
+		binary.BigEndian.PutUint64(buf[BoxHeaderSize:], sz)
+	} else {
+		buf = make([]byte, BoxHeaderSize)
+		binary.BigEndian.PutUint32(buf, uint32(sz))
+	}
 	strtobuf(buf[4:], b.Type(), 4)
 	_, err := w.Write(buf)
 	return err
@@ -82,38 +166,156 @@ func EncodeHeader(b Box, w io.Writer) error {
 // A box
 type Box interface {
 	Type() string
-	Size() int
+	Size() uint64
+	Encode(w io.Writer) error
 }
 
 type BoxDecoder func(r io.Reader) (Box, error)
 
-// Decode a box
+// RegisterBoxDecoder registers a decoder for a box type, replacing the default decoder if there is one.
+//
+// Boxes decoded by a custom decoder are stored in the Other list of their container (or of the MP4 for top-level boxes),
+// unless they replace a default decoder and return the same box type (e.g. *StcoBox).
+//
+// Decoders must be registered before decoding starts (e.g. from an init function), as the registry is not
+// protected against concurrent access.
+func RegisterBoxDecoder(typ string, d BoxDecoder) {
+	decoders[typ] = d
+}
+
+// RegisterUUIDDecoder registers a decoder for uuid boxes having the specified extended type.
+//
+// The decoder reads the whole box content, starting with the 16 bytes extended type, and the Encode
+// method of the decoded box must write it back after the header.
+func RegisterUUIDDecoder(uuid [16]byte, d BoxDecoder) {
+	uuidDecoders[uuid] = d
+}
+
+// A DecodeError is returned when a box cannot be decoded.
+//
+// Path is the path of the box (e.g. moov/trak[1]/mdia/minf/stbl/stsz), and Offset its absolute offset in the file.
+// Err is the underlying error (ErrBadFormat, ErrTruncatedHeader, ...), and can be tested using errors.Is.
+type DecodeError struct {
+	Path   string
+	Offset int64
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("@%d : %s", e.Offset, e.Err)
+	}
+	return fmt.Sprintf("%s @%d : %s", e.Path, e.Offset, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// decodeError wraps err in a DecodeError, unless it already is one (returned by a child box)
+func decodeError(path string, offset int64, err error) error {
+	var de *DecodeError
+	if errors.As(err, &de) {
+		return err
+	}
+	return &DecodeError{Path: path, Offset: offset, Err: err}
+}
+
+// indexedBoxes lists box types that can be repeated in a container, and are indexed in box paths (e.g. trak[1])
+var indexedBoxes = map[string]bool{
+	"trak": true,
+}
+
+// boxReader reads a box content, keeping track of the box path and of the absolute offset
+// to be able to report decoding errors.
+type boxReader struct {
+	r      io.Reader
+	path   string
+	offset int64
+	count  map[string]int
+}
+
+func (r *boxReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+// childPath returns the path of the next child box of type typ
+func (r *boxReader) childPath(typ string) string {
+	name := typ
+	if indexedBoxes[typ] {
+		if r.count == nil {
+			r.count = map[string]int{}
+		}
+		name = fmt.Sprintf("%s[%d]", typ, r.count[typ])
+		r.count[typ]++
+	}
+	if r.path == "" {
+		return name
+	}
+	return r.path + "/" + name
+}
+
+// Decode a box. Boxes with an unknown type are decoded as a RawBox.
+//
+// Errors are returned as a *DecodeError.
 func DecodeBox(h BoxHeader, r io.Reader) (Box, error) {
+	br, ok := r.(*boxReader)
+	if !ok {
+		br = &boxReader{r: r}
+	}
+	offset := br.offset - int64(h.HeaderSize)
+	path := br.childPath(h.Type)
+	cr := &boxReader{r: br, path: path, offset: br.offset}
+	if h.Size != 0 {
+		cr.r = io.LimitReader(br, int64(h.Size-h.HeaderSize))
+	}
+	r = cr
 	d := decoders[h.Type]
+	if h.Type == "uuid" {
+		var uuid [16]byte
+		_, err := io.ReadFull(r, uuid[:])
+		if err != nil {
+			return nil, decodeError(path, offset, ErrTruncatedHeader)
+		}
+		if ud := uuidDecoders[uuid]; ud != nil {
+			d = ud
+		}
+		r = io.MultiReader(bytes.NewReader(uuid[:]), r)
+	}
 	if d == nil {
-		log.Printf("Error while decoding %s : unknown box type", h.Type)
-		return nil, ErrUnknownBoxType
+		d = func(r io.Reader) (Box, error) {
+			return DecodeRaw(h.Type, r)
+		}
 	}
-	b, err := d(io.LimitReader(r, int64(h.Size-BoxHeaderSize)))
+	b, err := d(r)
 	if err != nil {
-		log.Printf("Error while decoding %s : %s", h.Type, err)
-		return nil, err
+		return nil, decodeError(path, offset, err)
+	}
+	if l, ok := b.(largeHeaderBox); ok && h.HeaderSize == LargeBoxHeaderSize {
+		l.setLargeHeader()
 	}
 	return b, nil
 }
 
 // Decode a container box
 func DecodeContainer(r io.Reader) ([]Box, error) {
+	br, ok := r.(*boxReader)
+	if !ok {
+		br = &boxReader{r: r}
+	}
 	l := []Box{}
 	for {
-		h, err := DecodeHeader(r)
+		offset := br.offset
+		h, err := DecodeHeader(br)
 		if err == io.EOF {
 			return l, nil
 		}
 		if err != nil {
-			return l, err
+			return l, decodeError(br.path, offset, err)
 		}
-		b, err := DecodeBox(h, r)
+		b, err := DecodeBox(h, br)
 		if err != nil {
 			return l, err
 		}
@@ -121,6 +323,61 @@ func DecodeContainer(r io.Reader) ([]Box, error) {
 	}
 }
 
+// boxTypes returns the types of a list of boxes, used to remember the order of decoded child boxes
+func boxTypes(l []Box) []string {
+	types := make([]string, len(l))
+	for i, b := range l {
+		types[i] = b.Type()
+	}
+	return types
+}
+
+// childrenSize returns the size of the child boxes of a container box
+func childrenSize(children []Box) uint64 {
+	var sz uint64
+	for _, b := range children {
+		sz += b.Size()
+	}
+	return sz
+}
+
+// sortBoxes orders boxes following order (the box types, in the order they were decoded), so that an
+// unmodified container is encoded exactly as it was decoded. Boxes that do not match order (added after
+// decoding) are placed last, in their original order.
+func sortBoxes(order []string, boxes []Box) []Box {
+	if len(order) == 0 {
+		return boxes
+	}
+	used := make([]bool, len(boxes))
+	sorted := make([]Box, 0, len(boxes))
+	for _, typ := range order {
+		for i, b := range boxes {
+			if !used[i] && b.Type() == typ {
+				used[i] = true
+				sorted = append(sorted, b)
+				break
+			}
+		}
+	}
+	for i, b := range boxes {
+		if !used[i] {
+			sorted = append(sorted, b)
+		}
+	}
+	return sorted
+}
+
+// encodeOrdered encodes child boxes to w, following order (see sortBoxes)
+func encodeOrdered(w io.Writer, order []string, children []Box) error {
+	for _, b := range sortBoxes(order, children) {
+		err := b.Encode(w)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // An 8.8 fixed point number
 type Fixed16 uint16
 
@@ -151,6 +408,23 @@ func putFixed32(bytes []byte, i Fixed32) {
 	binary.BigEndian.PutUint32(bytes, uint32(i))
 }
 
+// unitsToDuration converts a number of time units to a duration, without overflowing for large values
+func unitsToDuration(units int64, timescale uint32) time.Duration {
+	ts := int64(timescale)
+	return time.Duration(units/ts)*time.Second + time.Duration(units%ts)*time.Second/time.Duration(ts)
+}
+
+// durationToUnits converts a duration to a number of time units, without overflowing for large values
+func durationToUnits(d time.Duration, timescale uint32) int64 {
+	ts := int64(timescale)
+	return int64(d/time.Second)*ts + int64(d%time.Second)*ts/int64(time.Second)
+}
+
+// hasEntries returns true if data holds count entries of size bytes, starting at offset
+func hasEntries(data []byte, offset int, count uint32, size int) bool {
+	return uint64(len(data)) >= uint64(offset)+uint64(count)*uint64(size)
+}
+
 func strtobuf(out []byte, str string, l int) {
 	in := []byte(str)
 	if l < len(in) {
@@ -160,6 +434,14 @@ func strtobuf(out []byte, str string, l int) {
 	}
 }
 
+// boxSize returns the size of a box, header included, switching to a large header when needed
+func boxSize(contentSize uint64) uint64 {
+	if contentSize > math.MaxUint32-BoxHeaderSize {
+		return LargeBoxHeaderSize + contentSize
+	}
+	return BoxHeaderSize + contentSize
+}
+
 func makebuf(b Box) []byte {
-	return make([]byte, b.Size()-BoxHeaderSize)
+	return make([]byte, b.Size()-headerSize(b))
 }
@@ -4,19 +4,21 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/jfbus/mp4"
 	"github.com/jfbus/mp4/filter"
 )
 
 func main() {
-	start := flag.Int("start", 0, "start time (sec)")
-	duration := flag.Int("duration", 0, "duration (sec)")
+	start := flag.Float64("start", 0, "start time (sec)")
+	duration := flag.Float64("duration", 0, "duration (sec)")
+	faststart := flag.Bool("faststart", false, "move moov before mdat")
 	flag.Parse()
 	in := flag.Arg(0)
 	out := flag.Arg(1)
 	fd, err := os.Open(in)
-	v, err := mp4.Decode(fd)
+	v, err := mp4.DecodeReadSeeker(fd)
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -26,10 +28,17 @@ func main() {
 		if err != nil {
 			fmt.Println(err)
 		}
+		filters := []filter.Filter{}
 		if *start > 0 {
-			filter.EncodeFiltered(fd, v, filter.Clip(*start, *duration))
-		} else {
-			filter.EncodeFiltered(fd, v, filter.Noop())
+			filters = append(filters, filter.ClipDuration(seconds(*start), seconds(*duration)))
 		}
+		if *faststart {
+			filters = append(filters, filter.Faststart())
+		}
+		filter.EncodeFiltered(fd, v, filter.Chain(filters...))
 	}
 }
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
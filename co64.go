@@ -0,0 +1,79 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Chunk Large Offset Box (co64 - mandatory if stco is absent)
+//
+// Contained in : Sample Table box (stbl)
+//
+// Status: decoded
+//
+// This is the 64bits version of the chunk offset box (stco), used when offsets do not fit in 32 bits.
+//
+// The table contains the offsets (starting at the beginning of the file) for each chunk of data for the current track.
+type Co64Box struct {
+	header
+	Version     byte
+	Flags       [3]byte
+	ChunkOffset []uint64
+}
+
+func DecodeCo64(r io.Reader) (Box, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 {
+		return nil, ErrBadFormat
+	}
+	b := &Co64Box{
+		Version:     data[0],
+		Flags:       [3]byte{data[1], data[2], data[3]},
+		ChunkOffset: []uint64{},
+	}
+	ec := binary.BigEndian.Uint32(data[4:8])
+	if !hasEntries(data, 8, ec, 8) {
+		return nil, ErrBadFormat
+	}
+	for i := 0; i < int(ec); i++ {
+		chunk := binary.BigEndian.Uint64(data[(8 + 8*i):(16 + 8*i)])
+		b.ChunkOffset = append(b.ChunkOffset, chunk)
+	}
+	return b, nil
+}
+
+func (b *Co64Box) Type() string {
+	return "co64"
+}
+
+func (b *Co64Box) Size() uint64 {
+	return b.boxSize(8 + uint64(len(b.ChunkOffset))*8)
+}
+
+func (b *Co64Box) Dump() {
+	fmt.Println("Chunk byte offsets:")
+	for i, o := range b.ChunkOffset {
+		fmt.Printf(" #%d : starts at %d\n", i, o)
+	}
+}
+
+func (b *Co64Box) Encode(w io.Writer) error {
+	err := EncodeHeader(b, w)
+	if err != nil {
+		return err
+	}
+	buf := makebuf(b)
+	buf[0] = b.Version
+	buf[1], buf[2], buf[3] = b.Flags[0], b.Flags[1], b.Flags[2]
+	binary.BigEndian.PutUint32(buf[4:], uint32(len(b.ChunkOffset)))
+	for i := range b.ChunkOffset {
+		binary.BigEndian.PutUint64(buf[8+8*i:], b.ChunkOffset[i])
+	}
+	_, err = w.Write(buf)
+	return err
+}
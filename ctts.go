@@ -12,6 +12,7 @@ import (
 //
 // Status: version 0 decoded. version 1 uses int32 for offsets
 type CttsBox struct {
+	header
 	Version      byte
 	Flags        [3]byte
 	SampleCount  []uint32
@@ -23,6 +24,9 @@ func DecodeCtts(r io.Reader) (Box, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(data) < 8 {
+		return nil, ErrBadFormat
+	}
 	b := &CttsBox{
 		Version:      data[0],
 		Flags:        [3]byte{data[1], data[2], data[3]},
@@ -30,6 +34,9 @@ func DecodeCtts(r io.Reader) (Box, error) {
 		SampleOffset: []uint32{},
 	}
 	ec := binary.BigEndian.Uint32(data[4:8])
+	if !hasEntries(data, 8, ec, 8) {
+		return nil, ErrBadFormat
+	}
 	for i := 0; i < int(ec); i++ {
 		s_count := binary.BigEndian.Uint32(data[(8 + 8*i):(12 + 8*i)])
 		s_offset := binary.BigEndian.Uint32(data[(12 + 8*i):(16 + 8*i)])
@@ -43,8 +50,8 @@ func (b *CttsBox) Type() string {
 	return "ctts"
 }
 
-func (b *CttsBox) Size() int {
-	return BoxHeaderSize + 8 + len(b.SampleCount)*8
+func (b *CttsBox) Size() uint64 {
+	return b.boxSize(8 + uint64(len(b.SampleCount))*8)
 }
 
 func (b *CttsBox) Encode(w io.Writer) error {
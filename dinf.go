@@ -8,6 +8,7 @@ import "io"
 //
 // Status : decoded
 type DinfBox struct {
+	header
 	Dref *DrefBox
 }
 
@@ -18,13 +19,16 @@ func DecodeDinf(r io.Reader) (Box, error) {
 	}
 	d := &DinfBox{}
 	for _, b := range l {
-		switch b.Type() {
-		case "dref":
-			d.Dref = b.(*DrefBox)
+		switch c := b.(type) {
+		case *DrefBox:
+			d.Dref = c
 		default:
 			return nil, ErrBadFormat
 		}
 	}
+	if d.Dref == nil {
+		return nil, ErrBadFormat
+	}
 	return d, nil
 }
 
@@ -32,8 +36,8 @@ func (b *DinfBox) Type() string {
 	return "dinf"
 }
 
-func (b *DinfBox) Size() int {
-	return BoxHeaderSize + b.Dref.Size()
+func (b *DinfBox) Size() uint64 {
+	return b.boxSize(b.Dref.Size())
 }
 
 func (b *DinfBox) Encode(w io.Writer) error {
@@ -14,6 +14,7 @@ import (
 // Defines the location of the media data. If the data for the track is located in the same file
 // it contains nothing useful.
 type DrefBox struct {
+	header
 	Version    byte
 	Flags      [3]byte
 	notDecoded []byte
@@ -24,6 +25,9 @@ func DecodeDref(r io.Reader) (Box, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(data) < 4 {
+		return nil, ErrBadFormat
+	}
 	return &DrefBox{
 		Version:    data[0],
 		Flags:      [3]byte{data[1], data[2], data[3]},
@@ -35,8 +39,8 @@ func (b *DrefBox) Type() string {
 	return "dref"
 }
 
-func (b *DrefBox) Size() int {
-	return BoxHeaderSize + 4 + len(b.notDecoded)
+func (b *DrefBox) Size() uint64 {
+	return b.boxSize(4 + uint64(len(b.notDecoded)))
 }
 
 func (b *DrefBox) Encode(w io.Writer) error {
@@ -10,6 +10,7 @@ import "io"
 //
 // The edit box maps the presentation timeline to the media-time line
 type EdtsBox struct {
+	header
 	Elst *ElstBox
 }
 
@@ -20,13 +21,16 @@ func DecodeEdts(r io.Reader) (Box, error) {
 	}
 	e := &EdtsBox{}
 	for _, b := range l {
-		switch b.Type() {
-		case "elst":
-			e.Elst = b.(*ElstBox)
+		switch c := b.(type) {
+		case *ElstBox:
+			e.Elst = c
 		default:
 			return nil, ErrBadFormat
 		}
 	}
+	if e.Elst == nil {
+		return nil, ErrBadFormat
+	}
 	return e, nil
 }
 
@@ -34,8 +38,8 @@ func (b *EdtsBox) Type() string {
 	return "edts"
 }
 
-func (b *EdtsBox) Size() int {
-	return BoxHeaderSize + b.Elst.Size()
+func (b *EdtsBox) Size() uint64 {
+	return b.boxSize(b.Elst.Size())
 }
 
 func (b *EdtsBox) Dump() {
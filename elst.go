@@ -5,18 +5,30 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 )
 
 // Edit List Box (elst - optional)
 //
 // Contained in : Edit Box (edts)
 //
-// Status: version 0 decoded. version 1 not supported
+// Status: decoded (versions 0 and 1)
+//
+// Each entry defines a segment of the presentation timeline :
+//
+//   * segment duration : duration of the segment, in movie timescale units (see mvhd)
+//   * media time : start of the segment in the media, in media timescale units (see mdhd). -1 for an empty edit
+//   * media rate : playback rate (integer and fraction parts). A rate of 0 is a "dwell" edit
+//
+// Segment durations and media times are 64 bits wide in version 1, used as soon as an entry does not fit in
+// 32 bits (signed 32 bits for media times).
 type ElstBox struct {
+	header
 	Version                             byte
 	Flags                               [3]byte
-	SegmentDuration, MediaTime          []uint32 // should be uint32/int32 for version 0 and uint64/int32 for version 1
-	MediaRateInteger, MediaRateFraction []uint16 // should be int16
+	SegmentDuration                     []uint64
+	MediaTime                           []int64
+	MediaRateInteger, MediaRateFraction []int16
 }
 
 func DecodeElst(r io.Reader) (Box, error) {
@@ -24,24 +36,42 @@ func DecodeElst(r io.Reader) (Box, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(data) < 8 {
+		return nil, ErrBadFormat
+	}
 	b := &ElstBox{
 		Version:           data[0],
 		Flags:             [3]byte{data[1], data[2], data[3]},
-		SegmentDuration:   []uint32{},
-		MediaTime:         []uint32{},
-		MediaRateInteger:  []uint16{},
-		MediaRateFraction: []uint16{},
+		SegmentDuration:   []uint64{},
+		MediaTime:         []int64{},
+		MediaRateInteger:  []int16{},
+		MediaRateFraction: []int16{},
 	}
 	ec := binary.BigEndian.Uint32(data[4:8])
+	es := 12
+	if b.Version == 1 {
+		es = 20
+	}
+	if !hasEntries(data, 8, ec, es) {
+		return nil, ErrBadFormat
+	}
 	for i := 0; i < int(ec); i++ {
-		sd := binary.BigEndian.Uint32(data[(8 + 12*i):(12 + 12*i)])
-		mt := binary.BigEndian.Uint32(data[(12 + 12*i):(16 + 12*i)])
-		mri := binary.BigEndian.Uint16(data[(16 + 12*i):(18 + 12*i)])
-		mrf := binary.BigEndian.Uint16(data[(18 + 12*i):(20 + 12*i)])
+		var sd uint64
+		var mt int64
+		var rate []byte
+		if b.Version == 1 {
+			sd = binary.BigEndian.Uint64(data[(8 + 20*i):(16 + 20*i)])
+			mt = int64(binary.BigEndian.Uint64(data[(16 + 20*i):(24 + 20*i)]))
+			rate = data[(24 + 20*i):(28 + 20*i)]
+		} else {
+			sd = uint64(binary.BigEndian.Uint32(data[(8 + 12*i):(12 + 12*i)]))
+			mt = int64(int32(binary.BigEndian.Uint32(data[(12 + 12*i):(16 + 12*i)])))
+			rate = data[(16 + 12*i):(20 + 12*i)]
+		}
 		b.SegmentDuration = append(b.SegmentDuration, sd)
 		b.MediaTime = append(b.MediaTime, mt)
-		b.MediaRateInteger = append(b.MediaRateInteger, mri)
-		b.MediaRateFraction = append(b.MediaRateFraction, mrf)
+		b.MediaRateInteger = append(b.MediaRateInteger, int16(binary.BigEndian.Uint16(rate[0:2])))
+		b.MediaRateFraction = append(b.MediaRateFraction, int16(binary.BigEndian.Uint16(rate[2:4])))
 	}
 	return b, nil
 }
@@ -50,14 +80,37 @@ func (b *ElstBox) Type() string {
 	return "elst"
 }
 
-func (b *ElstBox) Size() int {
-	return BoxHeaderSize + 8 + len(b.SegmentDuration)*12
+func (b *ElstBox) version() byte {
+	if b.Version == 1 {
+		return 1
+	}
+	for i := range b.SegmentDuration {
+		if b.SegmentDuration[i] > math.MaxUint32 || b.MediaTime[i] > math.MaxInt32 || b.MediaTime[i] < math.MinInt32 {
+			return 1
+		}
+	}
+	return 0
+}
+
+func (b *ElstBox) entrySize() int {
+	if b.version() == 1 {
+		return 20
+	}
+	return 12
+}
+
+func (b *ElstBox) Size() uint64 {
+	return b.boxSize(8 + uint64(len(b.SegmentDuration)*b.entrySize()))
 }
 
 func (b *ElstBox) Dump() {
-	fmt.Println("Segment Duration:")
+	fmt.Println("Edit list:")
 	for i, d := range b.SegmentDuration {
-		fmt.Printf(" #%d: %d units\n", i, d)
+		if b.MediaTime[i] == -1 {
+			fmt.Printf(" #%d: %d units, empty\n", i, d)
+		} else {
+			fmt.Printf(" #%d: %d units, starting at media time %d\n", i, d, b.MediaTime[i])
+		}
 	}
 }
 
@@ -66,15 +119,24 @@ func (b *ElstBox) Encode(w io.Writer) error {
 	if err != nil {
 		return err
 	}
-	buf := make([]byte, b.Size()-BoxHeaderSize)
-	buf[0] = b.Version
+	buf := makebuf(b)
+	buf[0] = b.version()
 	buf[1], buf[2], buf[3] = b.Flags[0], b.Flags[1], b.Flags[2]
 	binary.BigEndian.PutUint32(buf[4:], uint32(len(b.SegmentDuration)))
+	es := b.entrySize()
 	for i := range b.SegmentDuration {
-		binary.BigEndian.PutUint32(buf[8+12*i:], b.SegmentDuration[i])
-		binary.BigEndian.PutUint32(buf[12+12*i:], b.MediaTime[i])
-		binary.BigEndian.PutUint16(buf[16+12*i:], b.MediaRateInteger[i])
-		binary.BigEndian.PutUint16(buf[18+12*i:], b.MediaRateFraction[i])
+		e := buf[8+es*i:]
+		if buf[0] == 1 {
+			binary.BigEndian.PutUint64(e, b.SegmentDuration[i])
+			binary.BigEndian.PutUint64(e[8:], uint64(b.MediaTime[i]))
+			e = e[16:]
+		} else {
+			binary.BigEndian.PutUint32(e, uint32(b.SegmentDuration[i]))
+			binary.BigEndian.PutUint32(e[4:], uint32(int32(b.MediaTime[i])))
+			e = e[8:]
+		}
+		binary.BigEndian.PutUint16(e, uint16(b.MediaRateInteger[i]))
+		binary.BigEndian.PutUint16(e[2:], uint16(b.MediaRateFraction[i]))
 	}
 	_, err = w.Write(buf)
 	return err
@@ -0,0 +1,25 @@
+package filter
+
+import (
+	"errors"
+)
+
+var ErrNotChainable = errors.New("filter can not be chained")
+
+// Chain returns a filter that applies several filters, in order, in a single encoding pass
+// (e.g. Chain(ClipDuration(begin, duration), SelectTracks(TrackHandler("soun")), Faststart())).
+//
+// Only filters built from planners (see PlanFilter) can be chained.
+func Chain(filters ...Filter) Filter {
+	c := &planFilter{}
+	for _, f := range filters {
+		pf, ok := f.(*planFilter)
+		if !ok {
+			c.err = ErrNotChainable
+			continue
+		}
+		c.planners = append(c.planners, pf.planners...)
+		c.faststart = c.faststart || pf.faststart
+	}
+	return c
+}
@@ -0,0 +1,131 @@
+package filter
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/jfbus/mp4"
+)
+
+// moovLast returns a fixture (moov first) with the moov box moved after the mdat box
+func moovLast(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := decodeFile(t, name)
+	moov := m.Moov.Size()
+	for _, tr := range m.Moov.Trak {
+		stbl := tr.Mdia.Minf.Stbl
+		l := make([]uint64, stbl.ChunkCount())
+		for i := range l {
+			l[i] = stbl.GetChunkOffset(i+1) - moov
+		}
+		stbl.SetChunkOffsets(l)
+	}
+	var buf bytes.Buffer
+	for _, f := range []func() error{
+		func() error { return m.Ftyp.Encode(&buf) },
+		func() error { return mp4.EncodeHeader(m.Mdat, &buf) },
+		func() error {
+			_, err := buf.Write(data[m.Mdat.Offset : uint64(m.Mdat.Offset)+m.Mdat.ContentSize])
+			return err
+		},
+		func() error { return m.Moov.Encode(&buf) },
+	} {
+		if err = f(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestChain(t *testing.T) {
+	data := moovLast(t, "av.mp4")
+	decode := func(data []byte) *mp4.MP4 {
+		m, err := mp4.DecodeAt(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	encode := func(m *mp4.MP4, f Filter) []byte {
+		var buf bytes.Buffer
+		err := EncodeFiltered(&buf, m, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	clip := func() Filter { return ClipDuration(time.Second, 2*time.Second) }
+	audio := func() Filter { return SelectTracks(TrackHandler("soun")) }
+
+	// a single pass gives the same media as filters applied one after the other
+	expected := encode(decode(encode(decode(encode(decode(data), clip())), audio())), Faststart())
+	actual := encode(decode(data), Chain(clip(), audio(), Faststart()))
+	if !bytes.Equal(expected, actual) {
+		t.Fatalf("chained filters differ from filters applied in turn (size %d, expected %d)", len(actual), len(expected))
+	}
+	out := decode(actual)
+	if string(actual[4:8]) != "ftyp" || string(actual[out.Ftyp.Size()+4:out.Ftyp.Size()+8]) != "moov" {
+		t.Fatal("moov is not located after ftyp")
+	}
+	if len(out.Moov.Trak) != 1 || out.Moov.Trak[0].Tkhd.TrackId != 2 {
+		t.Fatalf("unexpected tracks %v", out.Moov.Trak)
+	}
+	// the clip starts at the video key frame at 500.5ms, audio chunks hold 8 frames (170.67ms) : 500.5ms -> chunk 3
+	// (starts at 341.3ms), end 3000ms + 499.5ms -> chunk 21 (ends at 3584ms)
+	src := decode(data)
+	it := out.Moov.Trak[0].Samples()
+	for n := 17; n <= 168; n++ {
+		s, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := src.ReadSample(2, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := out.ReadSample(2, int(s.Number))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected, actual) {
+			t.Fatalf("sample %d differs from sample %d of the source", s.Number, n)
+		}
+	}
+	if _, err := it.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+// encodeFilter encodes the media unchanged, without planners
+type encodeFilter struct{}
+
+func (encodeFilter) FilterMoov(m *mp4.MoovBox) error {
+	return nil
+}
+
+func (encodeFilter) FilterMdat(w io.Writer, m *mp4.MdatBox) error {
+	return m.Encode(w)
+}
+
+func TestChainNotChainable(t *testing.T) {
+	for _, f := range []Filter{
+		Chain(encodeFilter{}),
+		Chain(ClipDuration(0, time.Second), encodeFilter{}, Faststart()),
+	} {
+		var buf bytes.Buffer
+		err := EncodeFiltered(&buf, decodeFile(t, "23976.mp4"), f)
+		if err != ErrNotChainable {
+			t.Errorf("expected ErrNotChainable, got %v", err)
+		}
+		_, err = EncodePlan(decodeFile(t, "23976.mp4"), f)
+		if err != ErrNotChainable {
+			t.Errorf("expected ErrNotChainable, got %v", err)
+		}
+	}
+}
@@ -2,9 +2,6 @@ package filter
 
 import (
 	"errors"
-	"io"
-	"log"
-	"sort"
 	"time"
 
 	"github.com/jfbus/mp4"
@@ -14,40 +11,48 @@ var (
 	ErrInvalidDuration = errors.New("invalid duration")
 	ErrClipOutside     = errors.New("clip zone is outside video")
 	ErrTruncatedChunk  = errors.New("chunk was truncated")
+	ErrUnsortedRanges  = errors.New("clip ranges are not sorted or overlap")
 )
 
 type chunk struct {
 	track                   int
-	index                   int
+	chunk                   *PlanChunk
 	firstTC, lastTC         time.Duration
-	descriptionID           uint32
-	oldOffset               uint32
-	samples                 []uint32
+	firstDTS                uint64
 	firstSample, lastSample uint32
 	keyFrame                bool
 	skip                    bool
 }
 
-func (c *chunk) size() uint32 {
-	var sz uint32
-	for _, ssz := range c.samples {
-		sz += ssz
-	}
-	return sz
-}
-
 type mdat []*chunk
 
-func (m mdat) Len() int {
-	return len(m)
-}
-
-func (m mdat) Less(i, j int) bool {
-	return m[i].oldOffset < m[j].oldOffset
-}
-
-func (m mdat) Swap(i, j int) {
-	m[i], m[j] = m[j], m[i]
+// chunkList returns the chunks of track tnum, all marked as skipped
+func chunkList(tnum int, tp *TrackPlan) mdat {
+	timescale := tp.Trak.Mdia.Mdhd.Timescale
+	// tracks without stss (all samples are sync samples) do not constrain the clip start
+	hasSync := tp.Trak.Mdia.Minf.Stbl.Stss != nil
+	chunks := mdat{}
+	for _, pc := range tp.Chunks {
+		if len(pc.Samples) == 0 {
+			continue
+		}
+		first, last := pc.Samples[0], pc.Samples[len(pc.Samples)-1]
+		c := &chunk{
+			track:       tnum,
+			chunk:       pc,
+			firstTC:     toDuration(first.DecodeTime, timescale),
+			lastTC:      toDuration(last.DecodeTime+uint64(last.Duration), timescale),
+			firstDTS:    first.DecodeTime,
+			firstSample: first.Number,
+			lastSample:  last.Number,
+			skip:        true,
+		}
+		for _, s := range pc.Samples {
+			c.keyFrame = c.keyFrame || (hasSync && s.Sync)
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks
 }
 
 func (m mdat) firstSample(tnum int, timecode time.Duration) uint32 {
@@ -55,7 +60,7 @@ func (m mdat) firstSample(tnum int, timecode time.Duration) uint32 {
 		if c.track != tnum {
 			continue
 		}
-		if timecode >= c.firstTC && timecode <= c.lastTC {
+		if timecode >= c.firstTC && timecode < c.lastTC {
 			return c.firstSample
 		}
 	}
@@ -63,314 +68,194 @@ func (m mdat) firstSample(tnum int, timecode time.Duration) uint32 {
 }
 
 func (m mdat) lastSample(tnum int, timecode time.Duration) uint32 {
+	var last *chunk
 	for _, c := range m {
 		if c.track != tnum {
 			continue
 		}
-		if timecode >= c.firstTC && timecode < c.lastTC {
+		if timecode > c.firstTC && timecode <= c.lastTC {
 			return c.lastSample
 		}
+		last = c
+	}
+	// the track ends before timecode
+	if last != nil && timecode > last.lastTC {
+		return last.lastSample
 	}
 	return 0
 }
 
+// A Range is a time range, from Begin to End
+type Range struct {
+	Begin, End time.Duration
+}
+
 type clipFilter struct {
-	err        error
-	begin, end time.Duration
-	mdatSize   uint32
-	chunks     mdat
+	err    error
+	ranges []Range
+	exact  bool
 }
 
 // Clip returns a filter that extracts a clip between begin and begin + duration (in seconds, starting at 0)
 // Il will try to include a key frame at the beginning, and keeps the same chunks as the origin media
 func Clip(begin, duration int) Filter {
-	f := &clipFilter{begin: time.Duration(begin) * time.Second, end: time.Duration(begin+duration) * time.Second}
-	if begin < 0 {
-		f.err = ErrClipOutside
-	}
-	return f
+	return ClipDuration(time.Duration(begin)*time.Second, time.Duration(duration)*time.Second)
 }
 
-func (f *clipFilter) FilterMoov(m *mp4.MoovBox) error {
-	if f.err != nil {
-		return f.err
-	}
-	if f.begin > time.Second*time.Duration(m.Mvhd.Duration)/time.Duration(m.Mvhd.Timescale) {
-		return ErrClipOutside
-	}
-	if f.end > time.Second*time.Duration(m.Mvhd.Duration)/time.Duration(m.Mvhd.Timescale) || f.end == f.begin {
-		f.end = time.Second * time.Duration(m.Mvhd.Duration) / time.Duration(m.Mvhd.Timescale)
-	}
-	oldSize := m.Size()
-	f.chunks = []*chunk{}
-	for tnum, t := range m.Trak {
-		f.buildChunkList(tnum, t)
-	}
-	f.syncToKF()
-	for tnum, t := range m.Trak {
-		// update stts, find first/last sample
-		f.updateSamples(tnum, t)
-		f.updateChunks(tnum, t)
-		// co64 ?
-	}
-	f.updateDurations(m)
-	sort.Sort(f.chunks)
-	for _, c := range f.chunks {
-		sz := 0
-		for _, ssz := range c.samples {
-			sz += int(ssz)
-		}
-	}
-	deltaOffset := m.Size() - oldSize
-	f.mdatSize = f.updateChunkOffsets(m, deltaOffset)
-	return nil
+// ClipDuration returns a filter that extracts a clip between begin and begin + duration, see Clip
+func ClipDuration(begin, duration time.Duration) Filter {
+	return ClipRanges([]Range{{Begin: begin, End: begin + duration}})
 }
 
-func (f *clipFilter) syncToKF() {
-	var tc time.Duration
-	for _, c := range f.chunks {
-		if c.keyFrame && c.firstTC <= f.begin {
-			tc = c.firstTC
-		}
-	}
-	f.end += f.begin - tc
-	f.begin = tc
+// ClipExact returns a filter that extracts a clip between begin and begin + duration, with a playback starting
+// exactly at begin. Samples from the preceding key frame are kept so that the clip can be decoded, and each track
+// gets an edit list (elst) skipping them.
+func ClipExact(begin, duration time.Duration) Filter {
+	f := clipRanges([]Range{{Begin: begin, End: begin + duration}})
+	f.exact = true
+	return PlanFilter(f)
 }
 
-func (f *clipFilter) buildChunkList(tnum int, t *mp4.TrakBox) {
-	stsz := t.Mdia.Minf.Stbl.Stsz
-	stsc := t.Mdia.Minf.Stbl.Stsc
-	stco := t.Mdia.Minf.Stbl.Stco
-	stts := t.Mdia.Minf.Stbl.Stts
-	stss := t.Mdia.Minf.Stbl.Stss
-	timescale := t.Mdia.Mdhd.Timescale
-	sci, ssi, ski := 0, 0, 0
-	for i, off := range stco.ChunkOffset {
-		c := &chunk{
-			track:       tnum,
-			index:       i + 1,
-			oldOffset:   uint32(off),
-			samples:     []uint32{},
-			firstSample: uint32(ssi + 1),
-			firstTC:     stts.GetTimeCode(uint32(ssi+1), timescale),
-		}
-		if sci < len(stsc.FirstChunk)-1 && c.index >= int(stsc.FirstChunk[sci+1]) {
-			sci++
-		}
-		c.descriptionID = stsc.SampleDescriptionID[sci]
-		samples := stsc.SamplesPerChunk[sci]
-		for samples > 0 {
-			c.samples = append(c.samples, stsz.GetSampleSize(ssi+1))
-			ssi++
-			samples--
+// ClipRanges returns a filter that extracts several time ranges and joins them in a single clip, in one pass.
+// Each range is extracted as with Clip (a range ending at its beginning extends to the end of the media).
+//
+// Ranges must be sorted and must not overlap (a range may begin where the previous one ends), otherwise
+// ErrUnsortedRanges is returned. Ranges sharing chunks (e.g. when a range starts at the key frame preceding its
+// beginning) are joined.
+func ClipRanges(ranges []Range) Filter {
+	return PlanFilter(clipRanges(ranges))
+}
+
+func clipRanges(ranges []Range) *clipFilter {
+	f := &clipFilter{ranges: ranges}
+	if len(ranges) == 0 {
+		f.err = ErrClipOutside
+	}
+	for i, r := range ranges {
+		if r.Begin < 0 || r.End < r.Begin {
+			f.err = ErrClipOutside
+			break
 		}
-		c.lastSample = uint32(ssi)
-		c.lastTC = stts.GetTimeCode(c.lastSample+1, timescale)
-		if stss != nil {
-			for ski < len(stss.SampleNumber) && stss.SampleNumber[ski] < c.lastSample {
-				c.keyFrame = true
-				ski++
+		if i > 0 {
+			prev := ranges[i-1]
+			if prev.End == prev.Begin || r.Begin < prev.End {
+				f.err = ErrUnsortedRanges
+				break
 			}
 		}
-		f.chunks = append(f.chunks, c)
 	}
+	return f
 }
 
-func (f *clipFilter) updateSamples(tnum int, t *mp4.TrakBox) {
-	// stts - sample duration
-	stts := t.Mdia.Minf.Stbl.Stts
-	oldCount, oldDelta := stts.SampleCount, stts.SampleTimeDelta
-	stts.SampleCount, stts.SampleTimeDelta = []uint32{}, []uint32{}
-
-	firstSample := f.chunks.firstSample(tnum, f.begin)
-	lastSample := f.chunks.lastSample(tnum, f.end)
-
-	log.Printf("first sample %d, last %d", firstSample, lastSample)
-	sample := uint32(1)
-	for i := 0; i < len(oldCount) && sample < lastSample; i++ {
-		if sample+oldCount[i] >= firstSample {
-			var current uint32
-			switch {
-			case sample < firstSample && sample+oldCount[i] > lastSample:
-				current = lastSample - firstSample + 1
-			case sample < firstSample:
-				current = oldCount[i] + sample - firstSample
-			case sample+oldCount[i] > lastSample:
-				current = oldCount[i] + sample - lastSample
-			default:
-				current = oldCount[i]
-			}
-			stts.SampleCount = append(stts.SampleCount, current)
-			stts.SampleTimeDelta = append(stts.SampleTimeDelta, oldDelta[i])
-		}
-		sample += oldCount[i]
+func (f *clipFilter) Plan(m *mp4.MoovBox, src *Plan) (*Plan, error) {
+	if f.err != nil {
+		return nil, f.err
 	}
-
-	// stss (key frames)
-	stss := t.Mdia.Minf.Stbl.Stss
-	if stss != nil {
-		oldNumber := stss.SampleNumber
-		stss.SampleNumber = []uint32{}
-		for _, n := range oldNumber {
-			if n >= firstSample && n <= lastSample {
-				stss.SampleNumber = append(stss.SampleNumber, n-uint32(firstSample))
-			}
-		}
+	duration := toDuration(m.Mvhd.Duration, m.Mvhd.Timescale)
+	chunks := mdat{}
+	for tnum, tp := range src.Tracks {
+		chunks = append(chunks, chunkList(tnum, tp)...)
 	}
-
-	// stsz (sample sizes)
-	stsz := t.Mdia.Minf.Stbl.Stsz
-	oldSize := stsz.SampleSize
-	stsz.SampleSize = []uint32{}
-	for n, sz := range oldSize {
-		if uint32(n) >= firstSample-1 && uint32(n) <= lastSample-1 {
-			stsz.SampleSize = append(stsz.SampleSize, sz)
+	var first Range
+	for i, r := range f.ranges {
+		if r.Begin > duration {
+			return nil, ErrClipOutside
+		}
+		if r.End > duration || r.End == r.Begin {
+			r.End = duration
 		}
+		if i == 0 {
+			first = r
+		}
+		begin, end := chunks.keyFrame(r.Begin), r.End
+		if !f.exact {
+			end += r.Begin - begin
+		}
+		chunks.keep(len(src.Tracks), begin, end)
 	}
-	log.Printf("stsz => %d", len(stsz.SampleSize))
-
-	// ctts - time offsets
-	ctts := t.Mdia.Minf.Stbl.Ctts
-	if ctts != nil {
-		oldCount, oldOffset := ctts.SampleCount, ctts.SampleOffset
-		ctts.SampleCount, ctts.SampleOffset = []uint32{}, []uint32{}
-		sample := uint32(1)
-		for i := 0; i < len(oldCount) && sample < lastSample; i++ {
-			if sample+oldCount[i] >= firstSample {
-				current := oldCount[i]
-				if sample < firstSample && sample+oldCount[i] > firstSample {
-					current += sample - firstSample
-				}
-				if sample+oldCount[i] > lastSample {
-					current += lastSample - sample - oldCount[i]
-				}
-
-				ctts.SampleCount = append(ctts.SampleCount, current)
-				ctts.SampleOffset = append(ctts.SampleOffset, oldOffset[i])
+	for tnum, tp := range src.Tracks {
+		tp.Chunks = []*PlanChunk{}
+		for _, c := range chunks {
+			if c.track == tnum && !c.skip {
+				tp.Chunks = append(tp.Chunks, c.chunk)
 			}
-			sample += oldCount[i]
 		}
 	}
-
+	if f.exact {
+		f.updateEdits(m, src, chunks, first.Begin, first.End)
+	}
+	return src, nil
 }
 
-func (f *clipFilter) updateChunks(tnum int, t *mp4.TrakBox) {
-	// stsc (sample to chunk) - full rebuild
-	stsc := t.Mdia.Minf.Stbl.Stsc
-	stsc.FirstChunk, stsc.SamplesPerChunk, stsc.SampleDescriptionID = []uint32{}, []uint32{}, []uint32{}
-	var firstChunk *chunk
-	var index, firstIndex uint32
-	firstSample := f.chunks.firstSample(tnum, f.begin)
-	lastSample := f.chunks.lastSample(tnum, f.end)
-	for _, c := range f.chunks {
-		if c.track != tnum {
-			continue
-		}
-		if c.firstSample > lastSample || c.lastSample < firstSample {
-			c.skip = true
-			continue
-		}
-		index++
-		if firstChunk == nil {
-			firstChunk = c
-			firstIndex = index
-		}
-		if len(c.samples) != len(firstChunk.samples) || c.descriptionID != firstChunk.descriptionID {
-			stsc.FirstChunk = append(stsc.FirstChunk, firstIndex)
-			stsc.SamplesPerChunk = append(stsc.SamplesPerChunk, uint32(len(firstChunk.samples)))
-			stsc.SampleDescriptionID = append(stsc.SampleDescriptionID, firstChunk.descriptionID)
-			firstChunk = c
-			firstIndex = index
+// keyFrame returns the time of the last key frame chunk starting before timecode (0 if none). When no chunk holds
+// a key frame (tracks without stss), the clip can start at any sample and timecode is returned.
+func (m mdat) keyFrame(timecode time.Duration) time.Duration {
+	var tc time.Duration
+	found := false
+	for _, c := range m {
+		found = found || c.keyFrame
+		if c.keyFrame && c.firstTC <= timecode {
+			tc = c.firstTC
 		}
 	}
-	if firstChunk != nil {
-		stsc.FirstChunk = append(stsc.FirstChunk, firstIndex)
-		stsc.SamplesPerChunk = append(stsc.SamplesPerChunk, uint32(len(firstChunk.samples)))
-		stsc.SampleDescriptionID = append(stsc.SampleDescriptionID, firstChunk.descriptionID)
+	if !found {
+		return timecode
 	}
-
-	// stco (chunk offsets) - build empty table to compute moov box size
-	stco := t.Mdia.Minf.Stbl.Stco
-	stco.ChunkOffset = make([]uint32, index)
+	return tc
 }
 
-func (f *clipFilter) updateChunkOffsets(m *mp4.MoovBox, deltaOff int) uint32 {
-	stco, i := make([]*mp4.StcoBox, len(m.Trak)), make([]int, len(m.Trak))
-	for tnum, t := range m.Trak {
-		stco[tnum] = t.Mdia.Minf.Stbl.Stco
-	}
-	var offset, sz uint32
-	for _, c := range f.chunks {
-		if offset == 0 {
-			offset = uint32(int(c.oldOffset) + deltaOff)
+// keep marks the chunks of all tracks between begin and end as kept
+func (m mdat) keep(tracks int, begin, end time.Duration) {
+	for tnum := 0; tnum < tracks; tnum++ {
+		first := m.firstSample(tnum, begin)
+		if first == 0 {
+			// the track ends before begin
+			continue
 		}
-		if !c.skip {
-			stco[c.track].ChunkOffset[i[c.track]] = offset + sz
-			i[c.track]++
-			sz += c.size()
+		last := m.lastSample(tnum, end)
+		for _, c := range m {
+			if c.track == tnum && c.firstSample <= last && c.lastSample >= first {
+				c.skip = false
+			}
 		}
 	}
-	return sz
 }
 
-func (f *clipFilter) updateDurations(m *mp4.MoovBox) {
+// updateEdits replaces the edit list of each track, so that the presentation starts at begin (in the original
+// timeline) and ends at end, skipping the samples kept before begin.
+func (f *clipFilter) updateEdits(m *mp4.MoovBox, p *Plan, chunks mdat, begin, end time.Duration) {
 	timescale := m.Mvhd.Timescale
-	m.Mvhd.Duration = 0
-	for tnum, t := range m.Trak {
-		var start, end time.Duration
-		for _, c := range f.chunks {
+	for tnum, tp := range p.Tracks {
+		t := tp.Trak
+		var first, last *chunk
+		for _, c := range chunks {
 			if c.track != tnum || c.skip {
 				continue
 			}
-			if start == 0 || c.firstTC < start {
-				start = c.firstTC
+			if first == nil || c.firstSample < first.firstSample {
+				first = c
 			}
-			if end == 0 || c.lastTC > end {
-				end = c.lastTC
+			if last == nil || c.lastSample > last.lastSample {
+				last = c
 			}
 		}
-		t.Mdia.Mdhd.Duration = uint32((end - start) * time.Duration(t.Mdia.Mdhd.Timescale) / time.Second)
-		t.Tkhd.Duration = uint32((end - start) * time.Duration(timescale) / time.Second)
-		if t.Tkhd.Duration > m.Mvhd.Duration {
-			m.Mvhd.Duration = t.Tkhd.Duration
-		}
-	}
-}
-
-func (f *clipFilter) FilterMdat(w io.Writer, m *mp4.MdatBox) error {
-	if f.err != nil {
-		return f.err
-	}
-	m.ContentSize = f.mdatSize
-	err := mp4.EncodeHeader(m, w)
-	if err != nil {
-		return err
-	}
-	var bufSize uint32
-	for _, c := range f.chunks {
-		if c.size() > bufSize {
-			bufSize = c.size()
+		if first == nil {
+			continue
 		}
-	}
-	buffer := make([]byte, bufSize)
-	for _, c := range f.chunks {
-		s := c.size()
-		n, err := io.ReadFull(m.Reader(), buffer[:s])
-		if err != nil {
-			return err
+		// media time at begin, using the original edit list
+		mt, ok := t.MediaTime(begin, timescale)
+		if !ok || mt < int64(first.firstDTS) {
+			mt = int64(first.firstDTS)
 		}
-		if n != int(s) {
-			return ErrTruncatedChunk
+		duration := end - begin
+		if d := last.lastTC - toDuration(uint64(mt), t.Mdia.Mdhd.Timescale); d < duration {
+			duration = d
 		}
-		if !c.skip {
-			n, err = w.Write(buffer[:s])
-			if err != nil {
-				return err
-			}
-			if n != int(s) {
-				return ErrTruncatedChunk
-			}
+		tp.Edits = &mp4.ElstBox{
+			SegmentDuration:   []uint64{toUnits(duration, timescale)},
+			MediaTime:         []int64{mt - int64(first.firstDTS)},
+			MediaRateInteger:  []int16{1},
+			MediaRateFraction: []int16{0},
 		}
 	}
-	return nil
 }
@@ -0,0 +1,247 @@
+package filter
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/jfbus/mp4"
+)
+
+func decodeFile(t *testing.T, name string) *mp4.MP4 {
+	data, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := mp4.DecodeAt(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func encodeFiltered(t *testing.T, m *mp4.MP4, f Filter) *mp4.MP4 {
+	var buf bytes.Buffer
+	err := EncodeFiltered(&buf, m, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := mp4.DecodeAt(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// checkClip checks that the video track of out holds samples first to last of the track of src, with rebuilt
+// sample tables (stts, stsz, stco, stss)
+func checkClip(t *testing.T, name string, src, out *mp4.MP4, first, last int) {
+	ss, cs := src.Moov.Trak[0].Mdia.Minf.Stbl, out.Moov.Trak[0].Mdia.Minf.Stbl
+	spc := int(ss.Stsc.SamplesPerChunk[0])
+	count := last - first + 1
+	if cs.SampleCount() != count {
+		t.Fatalf("%s : %d samples, expected %d (%d to %d)", name, cs.SampleCount(), count, first, last)
+	}
+	if len(cs.Stts.SampleCount) != 1 || cs.Stts.SampleCount[0] != uint32(count) || cs.Stts.SampleTimeDelta[0] != ss.Stts.SampleTimeDelta[0] {
+		t.Errorf("%s : unexpected stts %v %v", name, cs.Stts.SampleCount, cs.Stts.SampleTimeDelta)
+	}
+	if len(cs.Stsc.FirstChunk) != 1 || cs.Stsc.SamplesPerChunk[0] != uint32(spc) {
+		t.Errorf("%s : unexpected stsc %v %v", name, cs.Stsc.FirstChunk, cs.Stsc.SamplesPerChunk)
+	}
+	if cs.ChunkCount() != count/spc {
+		t.Fatalf("%s : %d chunks, expected %d", name, cs.ChunkCount(), count/spc)
+	}
+	offset := uint64(out.Mdat.Offset)
+	for i := 0; i < count; i++ {
+		if i%spc == 0 {
+			if co := cs.GetChunkOffset(i/spc + 1); co != offset {
+				t.Fatalf("%s : chunk %d at offset %d, expected %d", name, i/spc+1, co, offset)
+			}
+		}
+		size := cs.GetSampleSize(i + 1)
+		if size != ss.GetSampleSize(first+i) {
+			t.Fatalf("%s : sample %d has size %d, expected %d", name, i+1, size, ss.GetSampleSize(first+i))
+		}
+		offset += uint64(size)
+	}
+	if ss.Stss == nil {
+		// all samples are sync samples
+		if cs.Stss != nil {
+			t.Fatalf("%s : unexpected stss %v", name, cs.Stss.SampleNumber)
+		}
+	} else {
+		key := int(ss.Stss.SampleNumber[1] - ss.Stss.SampleNumber[0])
+		for i, n := range cs.Stss.SampleNumber {
+			if int(n) != i*key+1 {
+				t.Fatalf("%s : unexpected stss %v", name, cs.Stss.SampleNumber)
+			}
+		}
+		if len(cs.Stss.SampleNumber) != (count+key-1)/key {
+			t.Fatalf("%s : unexpected stss %v", name, cs.Stss.SampleNumber)
+		}
+	}
+	id := out.Moov.Trak[0].Tkhd.TrackId
+	for i := 0; i < count; i++ {
+		expected, err := src.ReadSample(id, first+i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := out.ReadSample(id, i+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected, actual) {
+			t.Fatalf("%s : sample %d differs from sample %d of the source", name, i+1, first+i)
+		}
+	}
+}
+
+// Frames last 1001/24000s (23.976 fps) or 1001/30000s (29.97 fps), chunks are 166.833ms long (4 or 5 frames),
+// key frames are every 500.5ms (every 3 chunks).
+func TestClipNTSC(t *testing.T) {
+	ms := time.Millisecond
+	for _, c := range []struct {
+		file            string
+		begin, duration time.Duration
+		first, last     int
+	}{
+		// begin 600ms -> key frame at 500.5ms, end 1100ms + 99.5ms -> chunk 8
+		{"23976.mp4", 600 * ms, 500 * ms, 13, 32},
+		{"2997.mp4", 600 * ms, 500 * ms, 16, 40},
+		// begin 250ms -> key frame at 0, end 1500ms -> chunk 9 (ends at 1501.5ms)
+		{"23976.mp4", 250 * ms, time.Second, 1, 36},
+		{"2997.mp4", 250 * ms, time.Second, 1, 45},
+		// begin on a key frame
+		{"23976.mp4", 500500 * time.Microsecond, time.Second, 13, 36},
+		{"2997.mp4", 500500 * time.Microsecond, time.Second, 16, 45},
+		// begin 1700ms -> key frame at 1501.5ms, end 2000ms + 198.5ms -> chunk 14
+		{"23976.mp4", 1700 * ms, 300 * ms, 37, 56},
+		{"2997.mp4", 1700 * ms, 300 * ms, 46, 70},
+	} {
+		name := c.file + " " + c.begin.String() + "+" + c.duration.String()
+		src := decodeFile(t, c.file)
+		out := encodeFiltered(t, decodeFile(t, c.file), ClipDuration(c.begin, c.duration))
+		checkClip(t, name, src, out, c.first, c.last)
+	}
+}
+
+// Without stss, all samples are sync samples : clips start at the chunk holding begin
+func TestClipNoSync(t *testing.T) {
+	ms := time.Millisecond
+	for _, c := range []struct {
+		begin, duration time.Duration
+		first, last     int
+	}{
+		// begin 2000ms -> chunk 12 (starts at 1835.2ms), end 3000ms -> chunk 18 (ends at 3003ms)
+		{2 * time.Second, time.Second, 45, 72},
+		// begin 600ms -> chunk 4 (starts at 500.5ms), end 1100ms -> chunk 7 (ends at 1167.8ms)
+		{600 * ms, 500 * ms, 13, 28},
+	} {
+		name := "23976.mp4 without stss " + c.begin.String() + "+" + c.duration.String()
+		src := decodeFile(t, "23976.mp4")
+		src.Moov.Trak[0].Mdia.Minf.Stbl.Stss = nil
+		m := decodeFile(t, "23976.mp4")
+		m.Moov.Trak[0].Mdia.Minf.Stbl.Stss = nil
+		out := encodeFiltered(t, m, ClipDuration(c.begin, c.duration))
+		checkClip(t, name, src, out, c.first, c.last)
+	}
+}
+
+// ClipExact keeps the samples from the preceding key frame, and starts playback at begin with an edit list
+func TestClipExact(t *testing.T) {
+	ms := time.Millisecond
+	for _, c := range []struct {
+		name   string
+		file   string
+		change func(m *mp4.MP4)
+		// per track : first and last kept samples, media time of the edit (in media timescale units)
+		first, last []int
+		mediaTime   []int64
+		duration    uint64
+	}{
+		// begin 600ms -> key frame at 500.5ms (sample 13, DTS 12012), media time 14400, end 1100ms -> chunk 7
+		{"23976.mp4", "23976.mp4", nil, []int{13}, []int{28}, []int64{14400 - 12012}, 500},
+		// an edit list is created
+		{"without edts", "23976.mp4", func(m *mp4.MP4) { m.Moov.Trak[0].Edts = nil }, []int{13}, []int{28}, []int64{2388}, 500},
+		// media starting at 2002 (83.4ms) : media time 16402 (683.4ms), the kept samples end at 1167.8ms
+		{"edit", "23976.mp4", func(m *mp4.MP4) {
+			m.Moov.Trak[0].Edts.Elst = &mp4.ElstBox{
+				SegmentDuration:   []uint64{4900},
+				MediaTime:         []int64{2002},
+				MediaRateInteger:  []int16{1},
+				MediaRateFraction: []int16{0},
+			}
+		}, []int{13}, []int{28}, []int64{16402 - 12012}, 484},
+		// begin 600ms -> chunk 4 (sample 13)
+		{"without stss", "23976.mp4", func(m *mp4.MP4) { m.Moov.Trak[0].Mdia.Minf.Stbl.Stss = nil }, []int{13}, []int{28}, []int64{2388}, 500},
+		// audio (no edts, no stss) : key frame at 500.5ms -> chunk 3 (sample 17, DTS 16384), media time 28800,
+		// end 1100ms -> chunk 7
+		{"av.mp4", "av.mp4", nil, []int{13, 17}, []int{28, 56}, []int64{2388, 28800 - 16384}, 500},
+	} {
+		src := decodeFile(t, c.file)
+		m := decodeFile(t, c.file)
+		if c.change != nil {
+			c.change(src)
+			c.change(m)
+		}
+		out := encodeFiltered(t, m, ClipExact(600*ms, 500*ms))
+		if out.Moov.Mvhd.Duration != c.duration {
+			t.Errorf("%s : duration %d, expected %d", c.name, out.Moov.Mvhd.Duration, c.duration)
+		}
+		for i, tr := range out.Moov.Trak {
+			stbl := tr.Mdia.Minf.Stbl
+			if n := stbl.SampleCount(); n != c.last[i]-c.first[i]+1 {
+				t.Fatalf("%s : track %d : %d samples, expected %d to %d", c.name, i, n, c.first[i], c.last[i])
+			}
+			for n := 1; n <= stbl.SampleCount(); n++ {
+				expected, err := src.ReadSample(tr.Tkhd.TrackId, c.first[i]+n-1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				actual, err := out.ReadSample(tr.Tkhd.TrackId, n)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(expected, actual) {
+					t.Fatalf("%s : track %d : sample %d differs from sample %d of the source", c.name, i, n, c.first[i]+n-1)
+				}
+			}
+			if tr.Edts == nil || tr.Edts.Elst == nil {
+				t.Fatalf("%s : track %d has no edit list", c.name, i)
+			}
+			elst := tr.Edts.Elst
+			if len(elst.SegmentDuration) != 1 || elst.SegmentDuration[0] != c.duration || elst.MediaTime[0] != c.mediaTime[i] {
+				t.Errorf("%s : track %d : unexpected edit list %v %v", c.name, i, elst.SegmentDuration, elst.MediaTime)
+			}
+			if tr.Tkhd.Duration != c.duration {
+				t.Errorf("%s : track %d : duration %d, expected %d", c.name, i, tr.Tkhd.Duration, c.duration)
+			}
+			// playback starts at begin
+			if mt, ok := tr.MediaTime(0, out.Moov.Mvhd.Timescale); !ok || mt != c.mediaTime[i] {
+				t.Errorf("%s : track %d : media time %d at 0", c.name, i, mt)
+			}
+		}
+	}
+}
+
+func TestClipRangesOrder(t *testing.T) {
+	s := time.Second
+	for _, c := range []struct {
+		name   string
+		ranges []Range
+		err    error
+	}{
+		{"sorted", []Range{{0, s}, {2 * s, 3 * s}}, nil},
+		{"contiguous", []Range{{0, s}, {s, 2 * s}}, nil},
+		{"unsorted", []Range{{2 * s, 3 * s}, {0, s}}, ErrUnsortedRanges},
+		{"overlapping", []Range{{0, 2 * s}, {s, 3 * s}}, ErrUnsortedRanges},
+		{"after the end", []Range{{s, s}, {2 * s, 3 * s}}, ErrUnsortedRanges},
+	} {
+		var buf bytes.Buffer
+		err := EncodeFiltered(&buf, decodeFile(t, "23976.mp4"), ClipRanges(c.ranges))
+		if err != c.err {
+			t.Errorf("%s : expected %v, got %v", c.name, c.err, err)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package filter
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/jfbus/mp4"
+)
+
+var ErrIncompatibleTracks = errors.New("tracks can not be concatenated")
+
+// Concat joins several media, one after the other, and returns the resulting media, to be encoded (Encode
+// or EncodeFiltered).
+//
+// All media must have the same tracks, in the same order, with the same handler type, timescale and codecs.
+// Sample descriptions that differ (e.g. codec configuration) are added to the sample description box (stsd).
+//
+// Edit lists of the first media are kept, with the media edit extended to the whole track : it may be preceded
+// by an empty edit (e.g. audio delay), ErrIncompatibleTracks is returned for other edit lists. Other top-level
+// boxes of the first media are kept (before the mdat box).
+//
+// The media are consumed : the result reuses the boxes of the first one, and reads the content of the mdat
+// boxes of each.
+func Concat(media ...*mp4.MP4) (*mp4.MP4, error) {
+	if len(media) == 0 {
+		return nil, ErrIncompatibleTracks
+	}
+	first := media[0]
+	for _, m := range media {
+		if m.Mdat == nil || m.Moov.Mvhd == nil || len(m.Moov.Trak) != len(first.Moov.Trak) {
+			return nil, ErrIncompatibleTracks
+		}
+		if m.Mdat.UnknownSize() {
+			return nil, mp4.ErrUnknownSize
+		}
+		for _, t := range m.Moov.Trak {
+			if t.Tkhd == nil || t.Mdia == nil || t.Mdia.Mdhd == nil || t.Mdia.Hdlr == nil || t.Mdia.Minf == nil ||
+				t.Mdia.Minf.Stbl == nil || t.Mdia.Minf.Stbl.Stsd == nil {
+				return nil, ErrIncompatibleTracks
+			}
+		}
+	}
+	tables := make([]*sampleTables, len(first.Moov.Trak))
+	entries := make([][][]byte, len(first.Moov.Trak))
+	for tnum, t := range first.Moov.Trak {
+		tables[tnum] = newSampleTables()
+		var err error
+		entries[tnum], err = t.Mdia.Minf.Stbl.Stsd.Entries()
+		if err != nil {
+			return nil, err
+		}
+	}
+	readers := []io.Reader{}
+	// chunk offsets are first computed relative to the new mdat content, the content of each mdat box of m
+	// being appended to it
+	var base uint64
+	for _, m := range media {
+		mdats := []*mp4.MdatBox{m.Mdat}
+		for _, b := range m.Other {
+			if mdat, ok := b.(*mp4.MdatBox); ok {
+				mdats = append(mdats, mdat)
+			}
+		}
+		deltas := make([]uint64, len(mdats))
+		for i, mdat := range mdats {
+			deltas[i] = base - uint64(mdat.Offset)
+			readers = append(readers, mdat.Reader())
+			base += mdat.ContentSize
+		}
+		for tnum, t := range m.Moov.Trak {
+			ids, err := matchTrack(first.Moov.Trak[tnum], t, &entries[tnum])
+			if err != nil {
+				return nil, err
+			}
+			err = appendTrack(tables[tnum], t, ids, func(offset uint64) uint64 {
+				return offset + deltas[containingMdat(mdats, m.Mdat, offset)]
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	moov := first.Moov
+	moov.Mvhd.Duration = 0
+	for tnum, t := range moov.Trak {
+		t.Mdia.Minf.Stbl.Stsd.SetEntries(entries[tnum])
+		tables[tnum].apply(t, moov.Mvhd.Timescale)
+		err := extendEdits(t, moov.Mvhd.Timescale)
+		if err != nil {
+			return nil, err
+		}
+		if t.Tkhd.Duration > moov.Mvhd.Duration {
+			moov.Mvhd.Duration = t.Tkhd.Duration
+		}
+	}
+	mdat, err := mp4.DecodeMdat(io.MultiReader(readers...))
+	if err != nil {
+		return nil, err
+	}
+	v := &mp4.MP4{Ftyp: first.Ftyp, Moov: moov, Mdat: mdat.(*mp4.MdatBox)}
+	for _, b := range first.Other {
+		// the content of the mdat boxes is in v.Mdat
+		if _, ok := b.(*mp4.MdatBox); !ok {
+			v.Other = append(v.Other, b)
+		}
+	}
+	v.Mdat.ContentSize = base
+	boxes := v.Boxes()
+	relocate(v, boxes)
+	v.Mdat.Offset = mdatOffset(boxes, v.Mdat)
+	return v, nil
+}
+
+// matchTrack checks that t can be appended to the first track ft, and returns the new sample description ids
+// of t, adding its sample descriptions to entries when needed
+func matchTrack(ft, t *mp4.TrakBox, entries *[][]byte) ([]uint32, error) {
+	if t.Mdia.Hdlr.HandlerType != ft.Mdia.Hdlr.HandlerType || t.Mdia.Mdhd.Timescale != ft.Mdia.Mdhd.Timescale {
+		return nil, ErrIncompatibleTracks
+	}
+	l, err := t.Mdia.Minf.Stbl.Stsd.Entries()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint32, len(l)+1)
+	for i, e := range l {
+		codec := false
+		for j, fe := range *entries {
+			if bytes.Equal(e, fe) {
+				ids[i+1] = uint32(j + 1)
+				break
+			}
+			codec = codec || bytes.Equal(e[4:8], fe[4:8])
+		}
+		if ids[i+1] != 0 {
+			continue
+		}
+		if !codec {
+			return nil, ErrIncompatibleTracks
+		}
+		*entries = append(*entries, e)
+		ids[i+1] = uint32(len(*entries))
+	}
+	return ids, nil
+}
+
+// appendTrack adds the samples of t to the tables, with new sample description ids and chunk offsets
+func appendTrack(tables *sampleTables, t *mp4.TrakBox, ids []uint32, offset func(uint64) uint64) error {
+	it := t.Samples()
+	var chunk uint32
+	for {
+		s, err := it.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if s.DescriptionID == 0 || int(s.DescriptionID) >= len(ids) {
+			return mp4.ErrBadFormat
+		}
+		if s.Chunk != chunk {
+			chunk = s.Chunk
+			tables.addChunk(offset(s.Offset), ids[s.DescriptionID])
+		}
+		tables.addSample(s, s.Size)
+	}
+}
+
+// extendEdits sets the duration of the media edit to the track duration. The media edit may be preceded by an
+// empty edit (e.g. audio delay), other edit lists can not be extended and ErrIncompatibleTracks is returned.
+func extendEdits(t *mp4.TrakBox, movieTimescale uint32) error {
+	if t.Edts == nil {
+		return nil
+	}
+	elst := t.Edts.Elst
+	if elst == nil {
+		t.Edts = nil
+		return nil
+	}
+	i := len(elst.SegmentDuration) - 1
+	if i < 0 || i > 1 || (i == 1 && elst.MediaTime[0] != -1) || elst.MediaTime[i] < 0 ||
+		uint64(elst.MediaTime[i]) > t.Mdia.Mdhd.Duration {
+		return ErrIncompatibleTracks
+	}
+	units := t.Mdia.Mdhd.Duration - uint64(elst.MediaTime[i])
+	elst.SegmentDuration[i] = toUnits(toDuration(units, t.Mdia.Mdhd.Timescale), movieTimescale)
+	t.Tkhd.Duration = 0
+	for _, d := range elst.SegmentDuration {
+		t.Tkhd.Duration += d
+	}
+	return nil
+}
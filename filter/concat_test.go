@@ -0,0 +1,55 @@
+package filter
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jfbus/mp4"
+)
+
+func TestConcatEdits(t *testing.T) {
+	a, b := decodeFile(t, "23976.mp4"), decodeFile(t, "23976.mp4")
+	// 500ms delay, then the whole media
+	a.Moov.Trak[0].Edts.Elst = &mp4.ElstBox{
+		SegmentDuration:   []uint64{500, 5005},
+		MediaTime:         []int64{-1, 0},
+		MediaRateInteger:  []int16{1, 1},
+		MediaRateFraction: []int16{0, 0},
+	}
+	free := &mp4.RawBox{BoxType: "free", Content: []byte("free space")}
+	a.Other = append(a.Other, free)
+	m, err := Concat(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = m.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out, err := mp4.DecodeAt(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	elst := out.Moov.Trak[0].Edts.Elst
+	if len(elst.SegmentDuration) != 2 || elst.MediaTime[0] != -1 || elst.SegmentDuration[0] != 500 ||
+		elst.MediaTime[1] != 0 || elst.SegmentDuration[1] != 10010 {
+		t.Fatalf("unexpected edit list %v %v", elst.SegmentDuration, elst.MediaTime)
+	}
+	if out.Moov.Trak[0].Tkhd.Duration != 10510 || out.Moov.Mvhd.Duration != 10510 {
+		t.Fatalf("unexpected durations %d %d", out.Moov.Trak[0].Tkhd.Duration, out.Moov.Mvhd.Duration)
+	}
+	if len(out.Other) != 1 || out.Other[0].Type() != "free" {
+		t.Fatalf("unexpected top-level boxes %v", out.Other)
+	}
+
+	a, b = decodeFile(t, "23976.mp4"), decodeFile(t, "23976.mp4")
+	a.Moov.Trak[0].Edts.Elst = &mp4.ElstBox{
+		SegmentDuration:   []uint64{2000, 3005},
+		MediaTime:         []int64{0, 48000},
+		MediaRateInteger:  []int16{1, 1},
+		MediaRateFraction: []int16{0, 0},
+	}
+	if _, err = Concat(a, b); err != ErrIncompatibleTracks {
+		t.Fatalf("expected ErrIncompatibleTracks, got %v", err)
+	}
+}
@@ -0,0 +1,7 @@
+package filter
+
+// Faststart returns a filter that moves the moov box before the mdat box, so that the media can be played
+// before being fully downloaded. Chunk offsets are updated, media data is copied unchanged.
+func Faststart() Filter {
+	return &planFilter{faststart: true}
+}
@@ -0,0 +1,148 @@
+package filter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/jfbus/mp4"
+)
+
+func TestFaststart(t *testing.T) {
+	data, err := ioutil.ReadFile("../testdata/moov_last.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := mp4.DecodeAt(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := encodeFiltered(t, src, Faststart())
+	moov := out.Moov.Size()
+	if pos := out.Ftyp.Size(); out.Mdat.Offset != int64(pos+moov+mp4.BoxHeaderSize) {
+		t.Fatalf("mdat content at offset %d, expected %d", out.Mdat.Offset, pos+moov+mp4.BoxHeaderSize)
+	}
+	for i, tr := range out.Moov.Trak {
+		ss, cs := src.Moov.Trak[i].Mdia.Minf.Stbl, tr.Mdia.Minf.Stbl
+		if cs.ChunkCount() != ss.ChunkCount() {
+			t.Fatalf("track %d : %d chunks, expected %d", i, cs.ChunkCount(), ss.ChunkCount())
+		}
+		// the mdat box moved by the size of the moov box
+		for n := 1; n <= ss.ChunkCount(); n++ {
+			if cs.GetChunkOffset(n) != ss.GetChunkOffset(n)+moov {
+				t.Fatalf("track %d : chunk %d at offset %d, expected %d", i, n, cs.GetChunkOffset(n), ss.GetChunkOffset(n)+moov)
+			}
+		}
+		checkSamples(t, "faststart", src, out, tr.Tkhd.TrackId)
+	}
+}
+
+// sparseFile is a media larger than 4GB : head, zeros up to the offset of tail, then tail
+type sparseFile struct {
+	head, tail []byte
+	offset     int64
+}
+
+func (f *sparseFile) size() int64 {
+	return f.offset + int64(len(f.tail))
+}
+
+func (f *sparseFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= f.size() {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(p) && off < f.size() {
+		switch {
+		case off < int64(len(f.head)):
+			p[n] = f.head[off]
+		case off >= f.offset:
+			p[n] = f.tail[off-f.offset]
+		default:
+			p[n] = 0
+		}
+		n++
+		off++
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Chunk offsets that exceed 4GB once the moov box moves before the mdat box are stored in co64
+func TestFaststartLarge(t *testing.T) {
+	data, err := ioutil.ReadFile("../testdata/moov_last.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := mp4.DecodeAt(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the mdat content is preceded by zeros : its samples end moov/2 bytes before 4GB
+	ftyp, moov := int64(m.Ftyp.Size()), int64(m.Moov.Size())
+	content := int64(m.Mdat.ContentSize)
+	gap := 1<<32 - (ftyp + mp4.BoxHeaderSize + content) - moov/2
+	for _, tr := range m.Moov.Trak {
+		stbl := tr.Mdia.Minf.Stbl
+		l := make([]uint64, stbl.ChunkCount())
+		for i := range l {
+			l[i] = stbl.GetChunkOffset(i+1) + uint64(gap)
+		}
+		stbl.SetChunkOffsets(l)
+		if stbl.Stco == nil {
+			t.Fatal("expected 32 bits chunk offsets")
+		}
+	}
+	var buf bytes.Buffer
+	if err = m.Moov.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	f := &sparseFile{head: append([]byte{}, data[:ftyp+mp4.BoxHeaderSize]...), offset: ftyp + mp4.BoxHeaderSize + gap}
+	binary.BigEndian.PutUint32(f.head[ftyp:], uint32(mp4.BoxHeaderSize+gap+content))
+	f.tail = append(append([]byte{}, data[m.Mdat.Offset:m.Mdat.Offset+content]...), buf.Bytes()...)
+
+	src, err := mp4.DecodeAt(f, f.size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := EncodePlan(src, Faststart())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the plan is too large to be written : decode the boxes located before the mdat content
+	var head []byte
+	for _, p := range o.Parts {
+		if p.Data == nil {
+			if p.Offset != src.Mdat.Offset || p.Length != int64(src.Mdat.ContentSize) {
+				t.Fatalf("unexpected range %d+%d, expected the mdat content", p.Offset, p.Length)
+			}
+			break
+		}
+		head = append(head, p.Data...)
+	}
+	out, err := mp4.Decode(bytes.NewReader(head))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shift := uint64(out.Moov.Size())
+	promoted := false
+	for i, tr := range out.Moov.Trak {
+		ss, cs := src.Moov.Trak[i].Mdia.Minf.Stbl, tr.Mdia.Minf.Stbl
+		if cs.Co64 == nil || cs.Stco != nil {
+			t.Fatalf("track %d : chunk offsets were not promoted to co64", i)
+		}
+		for n := 1; n <= ss.ChunkCount(); n++ {
+			if cs.GetChunkOffset(n) != ss.GetChunkOffset(n)+shift {
+				t.Fatalf("track %d : chunk %d at offset %d, expected %d", i, n, cs.GetChunkOffset(n), ss.GetChunkOffset(n)+shift)
+			}
+			promoted = promoted || cs.GetChunkOffset(n) >= 1<<32
+		}
+	}
+	if !promoted {
+		t.Fatal("no chunk offset exceeds 4GB")
+	}
+}
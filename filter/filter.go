@@ -1,11 +1,16 @@
 package filter
 
 import (
+	"bytes"
 	"io"
 
 	"github.com/jfbus/mp4"
 )
 
+// A Filter updates the moov box and writes the mdat box of a media.
+//
+// Filters of this package are built from planners (see PlanFilter), that only describe the samples of the
+// filtered media.
 type Filter interface {
 	// Updates the moov box
 	FilterMoov(m *mp4.MoovBox) error
@@ -14,18 +19,200 @@ type Filter interface {
 }
 
 // Encode media to a writer, filtering the media using the specified filter
+//
+// Filters compute chunk offsets as if the mdat content did not move, they are then updated to match the
+// position of the mdat box in the encoded media (e.g. when the moov box, located before, changed size).
+//
+// m is not modified : filters are applied to a copy of its moov box, and media decoded from a io.ReaderAt
+// (see mp4.DecodeAt) can be filtered several times, concurrently (with a filter per call).
 func EncodeFiltered(w io.Writer, m *mp4.MP4, f Filter) error {
-	err := m.Ftyp.Encode(w)
+	m, boxes, err := prepare(m, f)
 	if err != nil {
 		return err
 	}
+	for _, b := range boxes {
+		if b == mp4.Box(m.Mdat) {
+			err = f.FilterMdat(w, m.Mdat)
+		} else {
+			err = b.Encode(w)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepare filters a copy of m (see clone), and returns it with the top-level boxes to encode, with updated
+// chunk offsets
+func prepare(m *mp4.MP4, f Filter) (*mp4.MP4, []mp4.Box, error) {
+	m, err := clone(m)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sf, ok := f.(sourceFilter); ok && m.Mdat != nil {
+		sf.setSource(m)
+	}
 	err = f.FilterMoov(m.Moov)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	boxes := layout(m, f)
+	if m.Mdat != nil {
+		relocate(m, boxes)
+	}
+	return m, boxes, nil
+}
+
+// clone returns a copy of m that filters can update : the moov box is copied by encoding and decoding it, the
+// header of the mdat box is copied, its content is shared with m
+func clone(m *mp4.MP4) (*mp4.MP4, error) {
+	if m.Moov == nil {
+		return nil, mp4.ErrBadFormat
 	}
-	err = m.Moov.Encode(w)
+	var buf bytes.Buffer
+	err := m.Moov.Encode(&buf)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	h, err := mp4.DecodeHeader(&buf)
+	if err != nil {
+		return nil, err
+	}
+	b, err := mp4.DecodeBox(h, &buf)
+	if de, ok := err.(*mp4.DecodeError); ok {
+		// offsets of the copy are meaningless
+		return nil, de.Err
+	}
+	if err != nil {
+		return nil, err
+	}
+	moov, ok := b.(*mp4.MoovBox)
+	if !ok {
+		return nil, mp4.ErrBadFormat
+	}
+	c := *m
+	c.Moov = moov
+	if m.Mdat != nil {
+		mdat := *m.Mdat
+		c.Mdat = &mdat
+	}
+	return &c, nil
+}
+
+// moovFirster is implemented by filters that move the moov box before the mdat box
+type moovFirster interface {
+	moovFirst() bool
+}
+
+// sourceFilter is implemented by filters that may read the source media data before filtering the mdat box
+type sourceFilter interface {
+	setSource(m *mp4.MP4)
+}
+
+// mdatMerger is implemented by filters that write the samples of all mdat boxes in the first one (see
+// mp4.DecodeReadSeeker), other mdat boxes are then dropped
+type mdatMerger interface {
+	mergeMdat() bool
+}
+
+// layout returns the top-level boxes, in encoding order
+func layout(m *mp4.MP4, f Filter) []mp4.Box {
+	boxes := m.Boxes()
+	if mm, ok := f.(mdatMerger); ok && mm.mergeMdat() {
+		l := make([]mp4.Box, 0, len(boxes))
+		for _, b := range boxes {
+			if mdat, ok := b.(*mp4.MdatBox); !ok || mdat == m.Mdat {
+				l = append(l, b)
+			}
+		}
+		boxes = l
+	}
+	if mf, ok := f.(moovFirster); !ok || !mf.moovFirst() || m.Mdat == nil {
+		return boxes
+	}
+	l := make([]mp4.Box, 0, len(boxes))
+	for _, b := range boxes {
+		switch b {
+		case mp4.Box(m.Moov):
+		case mp4.Box(m.Mdat):
+			l = append(l, m.Moov, b)
+		default:
+			l = append(l, b)
+		}
+	}
+	return l
+}
+
+// relocate shifts chunk offsets by the distance between the original and the encoded position of the content
+// of the mdat box they are located in (m.Mdat when none). Shifting may switch stco <-> co64 and change the moov
+// size : loop until positions are stable.
+func relocate(m *mp4.MP4, boxes []mp4.Box) {
+	mdats := []*mp4.MdatBox{}
+	for _, b := range boxes {
+		if mdat, ok := b.(*mp4.MdatBox); ok {
+			mdats = append(mdats, mdat)
+		}
+	}
+	type chunks struct {
+		stbl    *mp4.StblBox
+		offsets []uint64
+		mdat    []int
+	}
+	tracks := make([]chunks, len(m.Moov.Trak))
+	for i, t := range m.Moov.Trak {
+		c := &tracks[i]
+		c.stbl = t.Mdia.Minf.Stbl
+		c.offsets = make([]uint64, c.stbl.ChunkCount())
+		c.mdat = make([]int, len(c.offsets))
+		for j := range c.offsets {
+			c.offsets[j] = c.stbl.GetChunkOffset(j + 1)
+			c.mdat[j] = containingMdat(mdats, m.Mdat, c.offsets[j])
+		}
+	}
+	deltas := make([]int64, len(mdats))
+	for {
+		stable := true
+		for i, b := range mdats {
+			delta := mdatOffset(boxes, b) - b.Offset
+			stable = stable && delta == deltas[i]
+			deltas[i] = delta
+		}
+		if stable {
+			return
+		}
+		for _, c := range tracks {
+			offsets := make([]uint64, len(c.offsets))
+			for j, o := range c.offsets {
+				offsets[j] = uint64(int64(o) + deltas[c.mdat[j]])
+			}
+			c.stbl.SetChunkOffsets(offsets)
+		}
+	}
+}
+
+// containingMdat returns the index of the mdat box whose content contains offset, or the index of def
+func containingMdat(mdats []*mp4.MdatBox, def *mp4.MdatBox, offset uint64) int {
+	n := 0
+	for i, b := range mdats {
+		if offset >= uint64(b.Offset) && offset < uint64(b.Offset)+b.ContentSize {
+			return i
+		}
+		if b == def {
+			n = i
+		}
+	}
+	return n
+}
+
+// mdatOffset returns the offset of the content of the mdat box b when boxes are encoded
+func mdatOffset(boxes []mp4.Box, b *mp4.MdatBox) int64 {
+	var offset uint64
+	for _, bb := range boxes {
+		if bb == mp4.Box(b) {
+			break
+		}
+		offset += bb.Size()
 	}
-	return f.FilterMdat(w, m.Mdat)
+	return int64(offset + b.Size() - b.ContentSize)
 }
@@ -0,0 +1,180 @@
+package filter
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/jfbus/mp4"
+)
+
+// An OutputPlan describes an encoded media as a list of parts : encoded data (boxes, mdat header, replaced
+// samples), ranges of the source media, to be read from Source, and ranges of Temp, holding the samples rewritten
+// by Transform.
+//
+// Serving a media from a plan avoids reading the media data in user space : when Source is a *os.File (media
+// decoded by mp4.DecodeReadSeeker from a *os.File), WriteTo copies ranges from the file, which can use sendfile
+// when writing to a network connection. Media decoded by mp4.DecodeAt have a io.SectionReader as Source, their
+// ranges are copied in user space.
+type OutputPlan struct {
+	Source io.ReaderAt
+	// Temp is the temporary file of the filter (see Transform), or nil. It is removed by Close.
+	Temp  *os.File
+	Parts []OutputPart
+}
+
+// An OutputPart is either Data, or Length bytes located at Offset in the source media (or in the Temp file of
+// the plan when Temp is true)
+type OutputPart struct {
+	Data   []byte
+	Offset int64
+	Length int64
+	Temp   bool
+}
+
+// EncodePlan filters the media and returns the plan of the encoded media, see EncodeFiltered.
+//
+// The media must have been decoded from a io.ReaderAt (see mp4.DecodeAt, or mp4.DecodeReadSeeker with a
+// *os.File to get a *os.File as Source), otherwise the mdat box is encoded in memory.
+func EncodePlan(m *mp4.MP4, f Filter) (*OutputPlan, error) {
+	m, boxes, err := prepare(m, f)
+	if err != nil {
+		return nil, err
+	}
+	o := &OutputPlan{}
+	var buf bytes.Buffer
+	for _, b := range boxes {
+		mp, ok := f.(mdatPlanner)
+		if b != mp4.Box(m.Mdat) {
+			err = b.Encode(&buf)
+		} else if !ok || m.Mdat.ReaderAt() == nil {
+			err = f.FilterMdat(&buf, m.Mdat)
+		} else {
+			o.addData(buf.Bytes())
+			buf = bytes.Buffer{}
+			err = mp.planMdat(o, m.Mdat)
+			o.Source = m.Mdat.ReaderAt()
+		}
+		if err != nil {
+			o.Close()
+			return nil, err
+		}
+	}
+	o.addData(buf.Bytes())
+	return o, nil
+}
+
+// mdatPlanner is implemented by filters that can describe the filtered mdat box with ranges of the source media
+type mdatPlanner interface {
+	planMdat(o *OutputPlan, m *mp4.MdatBox) error
+}
+
+// Close removes the Temp file of the plan. The plan can not be written afterwards.
+func (o *OutputPlan) Close() error {
+	if o.Temp == nil {
+		return nil
+	}
+	err := o.Temp.Close()
+	if rerr := os.Remove(o.Temp.Name()); err == nil {
+		err = rerr
+	}
+	o.Temp = nil
+	return err
+}
+
+// Size returns the size of the encoded media
+func (o *OutputPlan) Size() int64 {
+	var sz int64
+	for _, p := range o.Parts {
+		if p.Data != nil {
+			sz += int64(len(p.Data))
+		} else {
+			sz += p.Length
+		}
+	}
+	return sz
+}
+
+// WriteTo writes the encoded media to w (implements io.WriterTo). A plan can be written several times,
+// concurrently.
+//
+// When Source is a *os.File, the file is opened again by its name (see os.File.Name) for each call, ranges being
+// copied using the offset of the new file.
+func (o *OutputPlan) WriteTo(w io.Writer) (int64, error) {
+	src := o.Source
+	if f, ok := src.(*os.File); ok {
+		rf, err := os.Open(f.Name())
+		if err != nil {
+			return 0, err
+		}
+		defer rf.Close()
+		src = rf
+	}
+	var written int64
+	for _, p := range o.Parts {
+		var n int64
+		var err error
+		if p.Data != nil {
+			var nw int
+			nw, err = w.Write(p.Data)
+			n = int64(nw)
+		} else {
+			n, err = o.copyRange(w, src, p)
+		}
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// copyRange copies a range of src (or of the Temp file) to w. A *os.File is read through a io.LimitedReader,
+// so that io.Copy can use sendfile (see net.TCPConn.ReadFrom).
+func (o *OutputPlan) copyRange(w io.Writer, src io.ReaderAt, p OutputPart) (int64, error) {
+	var r io.Reader
+	if p.Temp {
+		r = io.NewSectionReader(o.Temp, p.Offset, p.Length)
+	} else if f, ok := src.(*os.File); ok {
+		_, err := f.Seek(p.Offset, io.SeekStart)
+		if err != nil {
+			return 0, err
+		}
+		r = io.LimitReader(f, p.Length)
+	} else {
+		r = io.NewSectionReader(src, p.Offset, p.Length)
+	}
+	n, err := io.Copy(w, r)
+	if err == nil && n != p.Length {
+		err = ErrTruncatedChunk
+	}
+	return n, err
+}
+
+func (o *OutputPlan) addHeader(b mp4.Box) error {
+	var buf bytes.Buffer
+	err := mp4.EncodeHeader(b, &buf)
+	if err == nil {
+		o.addData(buf.Bytes())
+	}
+	return err
+}
+
+func (o *OutputPlan) addData(data []byte) {
+	if len(data) > 0 {
+		o.Parts = append(o.Parts, OutputPart{Data: data})
+	}
+}
+
+// addRange adds a range of the source media (or of the Temp file), merged with the previous part when contiguous
+func (o *OutputPlan) addRange(offset, length int64, temp bool) {
+	if length == 0 {
+		return
+	}
+	if n := len(o.Parts); n > 0 && o.Parts[n-1].Data == nil && o.Parts[n-1].Temp == temp &&
+		o.Parts[n-1].Offset+o.Parts[n-1].Length == offset {
+		o.Parts[n-1].Length += length
+		return
+	}
+	o.Parts = append(o.Parts, OutputPart{Offset: offset, Length: length, Temp: temp})
+}
@@ -0,0 +1,67 @@
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jfbus/mp4"
+)
+
+func TestOutputPlanFile(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/2997.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "mp4-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err = f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	var expected bytes.Buffer
+	err = EncodeFiltered(&expected, decodeFile(t, "2997.mp4"), ClipDuration(0, 2500*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := mp4.DecodeReadSeeker(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := EncodePlan(m, ClipDuration(0, 2500*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := o.Source.(*os.File); !ok {
+		t.Fatalf("unexpected source %T", o.Source)
+	}
+	// each WriteTo reads the file from its own handle
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var actual bytes.Buffer
+			n, err := o.WriteTo(&actual)
+			if err == nil && (n != o.Size() || !bytes.Equal(expected.Bytes(), actual.Bytes())) {
+				err = fmt.Errorf("encoded media differs (size %d, expected %d)", n, expected.Len())
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
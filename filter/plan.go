@@ -0,0 +1,663 @@
+package filter
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/jfbus/mp4"
+)
+
+// A Plan describes the media produced by a filter : its tracks and, for each track, the chunks of samples
+// written in the mdat box.
+//
+// Sample data is located in the source media (Offset and Size) unless replaced (Data). The sample tables
+// are rebuilt from the timing, sync and sample description of the samples.
+type Plan struct {
+	Tracks []*TrackPlan
+	ra     io.ReaderAt
+	temp   *tempFile
+	mdats  []*mp4.MdatBox
+}
+
+// A TrackPlan lists the chunks of a track, in decoding order
+type TrackPlan struct {
+	Trak   *mp4.TrakBox
+	Chunks []*PlanChunk
+	// Edits replaces the edit list of the track when not nil, the track duration is then the duration of the edits.
+	// Otherwise, the edit list of a track whose samples changed is trimmed to its samples.
+	Edits *mp4.ElstBox
+}
+
+// A PlanChunk is a list of samples stored together in the mdat box
+type PlanChunk struct {
+	Samples []PlanSample
+}
+
+// A PlanSample is a sample of the filtered media
+type PlanSample struct {
+	mp4.Sample
+	// Data replaces the data of the sample when not nil
+	Data []byte
+	// stored is true when the data of the sample was replaced by Transform, and stored in the temporary file of
+	// the filter at tempOffset
+	stored     bool
+	tempOffset int64
+}
+
+// ReadSample returns the data of a sample : the replaced data, or the data read from the source media.
+//
+// The source media must have been decoded from a io.ReaderAt (see mp4.DecodeAt), and encoded by EncodeFiltered,
+// otherwise mp4.ErrNotSeekable is returned. mp4.ErrBadFormat is returned when the sample is not located in a
+// mdat box of the source media.
+func (p *Plan) ReadSample(s *PlanSample) ([]byte, error) {
+	if s.Data != nil {
+		return s.Data, nil
+	}
+	ra := p.ra
+	offset := int64(s.Offset)
+	if s.stored {
+		ra, offset = p.temp, s.tempOffset
+	}
+	if ra == nil {
+		return nil, mp4.ErrNotSeekable
+	}
+	if !s.stored && !p.inMdat(s) {
+		// the size comes from the sample tables, it is checked before allocating the data
+		return nil, mp4.ErrBadFormat
+	}
+	data := make([]byte, s.Size)
+	n, err := ra.ReadAt(data, offset)
+	if n == len(data) {
+		return data, nil
+	}
+	if err == io.EOF {
+		err = ErrTruncatedChunk
+	}
+	return nil, err
+}
+
+func (p *Plan) inMdat(s *PlanSample) bool {
+	for _, mdat := range p.mdats {
+		if mdat.Contains(s.Offset, uint64(s.Size)) {
+			return true
+		}
+	}
+	return false
+}
+
+// store replaces the data of a sample, the data being written to the temporary file of the filter
+func (p *Plan) store(s *PlanSample, data []byte) error {
+	if p.temp == nil {
+		return mp4.ErrNotSeekable
+	}
+	offset, err := p.temp.append(data)
+	if err != nil {
+		return err
+	}
+	s.Data = nil
+	s.Size = uint32(len(data))
+	s.stored, s.tempOffset = true, offset
+	return nil
+}
+
+func (s *PlanSample) size() uint32 {
+	if s.Data != nil {
+		return uint32(len(s.Data))
+	}
+	return s.Size
+}
+
+// A Planner builds the plan of a filter.
+//
+// src is the plan of the source media (or the plan returned by the previous planner, see Chain), m is updated
+// to match it : tracks and sample tables are rebuilt from each plan, and sample numbers and times are renumbered.
+// Plan may modify src and other boxes of m (e.g. mvhd), and returns the new plan.
+type Planner interface {
+	Plan(m *mp4.MoovBox, src *Plan) (*Plan, error)
+}
+
+type planFilter struct {
+	err       error
+	planners  []Planner
+	faststart bool
+	ra        io.ReaderAt
+	temp      *tempFile
+	spooled   int64 // size of the mdat content copied to temp, see spool
+	mdat      *mp4.MdatBox
+	mdats     []*mp4.MdatBox // mdat boxes of the source media, mdat first
+	orig      map[*mp4.TrakBox]*TrackPlan
+	base      uint64 // offset of the first chunk of the source media
+	changed   bool
+	chunks    []*PlanChunk // in mdat order
+	mdatSize  uint64
+}
+
+// PlanFilter returns a filter applying planners, in order. Sample tables, chunk offsets and the mdat box
+// are computed from the resulting plan : kept chunks are packed in the order of their source offsets, after
+// the data located before the first chunk of the source media.
+func PlanFilter(planners ...Planner) Filter {
+	return &planFilter{planners: planners}
+}
+
+func (f *planFilter) FilterMoov(m *mp4.MoovBox) error {
+	if f.err != nil {
+		return f.err
+	}
+	err := f.filterMoov(m)
+	if err != nil {
+		f.closeTemp()
+	}
+	return err
+}
+
+func (f *planFilter) filterMoov(m *mp4.MoovBox) error {
+	p, err := f.source(m)
+	if err != nil {
+		return err
+	}
+	if f.readsData() {
+		f.temp, err = newTempFile()
+		if err != nil {
+			return err
+		}
+		if f.ra == nil && f.mdat != nil {
+			err = f.spool()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	f.changed = false
+	for _, pl := range f.planners {
+		p.ra, p.temp, p.mdats = f.ra, f.temp, f.mdats
+		p, err = pl.Plan(m, p)
+		if err != nil {
+			return err
+		}
+		f.apply(m, p)
+	}
+	if f.changed && len(f.mdats) > 1 && f.ra == nil {
+		// samples of the other mdat boxes can not be read from the reader of the first one
+		end := uint64(f.mdat.Offset) + f.mdat.ContentSize
+		for _, c := range f.chunks {
+			s := c.Samples[len(c.Samples)-1]
+			if s.Data == nil && s.Offset+uint64(s.Size) > end {
+				return mp4.ErrNotSeekable
+			}
+		}
+	}
+	if f.changed && f.mdat != nil {
+		// the header size of the mdat box depends on its content size, needed to relocate chunks
+		prefix, err := f.prefix(f.mdat)
+		if err != nil {
+			return err
+		}
+		f.mdat.ContentSize = prefix + f.mdatSize
+	}
+	return nil
+}
+
+// source returns the plan of the source media, keeping a copy to detect unchanged tracks.
+//
+// The boxes used by planners and to rebuild tracks (mvhd, tkhd, mdhd, stbl) are checked once here : mp4.ErrBadFormat
+// is returned when one is missing, or when a timescale is 0.
+func (f *planFilter) source(m *mp4.MoovBox) (*Plan, error) {
+	if m.Mvhd == nil || m.Mvhd.Timescale == 0 {
+		return nil, mp4.ErrBadFormat
+	}
+	p := &Plan{}
+	f.orig = map[*mp4.TrakBox]*TrackPlan{}
+	f.base = 0
+	for _, t := range m.Trak {
+		if t.Tkhd == nil || t.Mdia == nil || t.Mdia.Mdhd == nil || t.Mdia.Mdhd.Timescale == 0 || t.Mdia.Minf == nil ||
+			t.Mdia.Minf.Stbl == nil {
+			return nil, mp4.ErrBadFormat
+		}
+		tp := &TrackPlan{Trak: t}
+		orig := &TrackPlan{Trak: t}
+		it := t.Samples()
+		var c *PlanChunk
+		for {
+			s, err := it.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if c == nil || s.Chunk != c.Samples[0].Chunk {
+				c = &PlanChunk{}
+				tp.Chunks = append(tp.Chunks, c)
+				orig.Chunks = append(orig.Chunks, &PlanChunk{})
+				if f.base == 0 || s.Offset < f.base {
+					f.base = s.Offset
+				}
+			}
+			c.Samples = append(c.Samples, PlanSample{Sample: s})
+			oc := orig.Chunks[len(orig.Chunks)-1]
+			oc.Samples = append(oc.Samples, PlanSample{Sample: s})
+		}
+		p.Tracks = append(p.Tracks, tp)
+		f.orig[t] = orig
+	}
+	if f.mdat != nil && !f.mdat.UnknownSize() && f.base > uint64(f.mdat.Offset)+f.mdat.ContentSize {
+		// all chunks are located in other mdat boxes : no data is kept before the first chunk
+		f.base = uint64(f.mdat.Offset)
+	}
+	return p, nil
+}
+
+// unchanged returns true if tp has the samples and chunks of the source track
+func (f *planFilter) unchanged(tp *TrackPlan) bool {
+	o := f.orig[tp.Trak]
+	if o == nil || tp.Edits != nil || len(tp.Chunks) != len(o.Chunks) {
+		return false
+	}
+	for i, c := range tp.Chunks {
+		oc := o.Chunks[i]
+		if len(c.Samples) != len(oc.Samples) {
+			return false
+		}
+		for j := range c.Samples {
+			if c.Samples[j].Data != nil || c.Samples[j].stored || c.Samples[j].Sample != oc.Samples[j].Sample {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// apply updates m to match p. Nothing is changed as long as the plan matches the source media.
+func (f *planFilter) apply(m *mp4.MoovBox, p *Plan) {
+	same := !f.changed && len(p.Tracks) == len(m.Trak)
+	for i, tp := range p.Tracks {
+		same = same && tp.Trak == m.Trak[i] && f.unchanged(tp)
+	}
+	if same {
+		return
+	}
+	f.changed = true
+
+	f.chunks = []*PlanChunk{}
+	for _, tp := range p.Tracks {
+		for _, c := range tp.Chunks {
+			if len(c.Samples) > 0 {
+				f.chunks = append(f.chunks, c)
+			}
+		}
+	}
+	sort.SliceStable(f.chunks, func(i, j int) bool {
+		return f.chunks[i].Samples[0].Offset < f.chunks[j].Samples[0].Offset
+	})
+	offsets := make(map[*PlanChunk]uint64, len(f.chunks))
+	f.mdatSize = 0
+	for _, c := range f.chunks {
+		offsets[c] = f.base + f.mdatSize
+		for i := range c.Samples {
+			f.mdatSize += uint64(c.Samples[i].size())
+		}
+	}
+
+	m.Trak = []*mp4.TrakBox{}
+	m.Mvhd.Duration = 0
+	for _, tp := range p.Tracks {
+		t := tp.Trak
+		m.Trak = append(m.Trak, t)
+		if f.unchanged(tp) {
+			l := []uint64{}
+			for _, c := range tp.Chunks {
+				l = append(l, offsets[c])
+			}
+			t.Mdia.Minf.Stbl.SetChunkOffsets(l)
+		} else {
+			tables := newSampleTables()
+			for _, c := range tp.Chunks {
+				if len(c.Samples) == 0 {
+					continue
+				}
+				tables.addChunk(offsets[c], c.Samples[0].DescriptionID)
+				for i := range c.Samples {
+					tables.addSample(c.Samples[i].Sample, c.Samples[i].size())
+				}
+			}
+			tables.apply(t, m.Mvhd.Timescale)
+			start := tp.decodeTime()
+			tp.renumber()
+			if tp.Edits == nil {
+				tp.trimEdits(start, m.Mvhd.Timescale)
+			}
+		}
+		if tp.Edits != nil {
+			if t.Edts == nil {
+				t.Edts = &mp4.EdtsBox{}
+			}
+			t.Edts.Elst = tp.Edits
+			t.Tkhd.Duration = 0
+			for _, d := range tp.Edits.SegmentDuration {
+				t.Tkhd.Duration += d
+			}
+			tp.Edits = nil
+		}
+		if t.Tkhd.Duration > m.Mvhd.Duration {
+			m.Mvhd.Duration = t.Tkhd.Duration
+		}
+	}
+}
+
+// decodeTime returns the decode time of the first sample of the track
+func (tp *TrackPlan) decodeTime() uint64 {
+	for _, c := range tp.Chunks {
+		if len(c.Samples) > 0 {
+			return c.Samples[0].DecodeTime
+		}
+	}
+	return 0
+}
+
+// trimEdits rebuilds the edit list of a track whose samples changed, start being the decode time of its first
+// sample before renumbering. The edit list keeps its leading empty edit (e.g. audio delay) when the track still
+// starts with its first sample, and a single media edit trimmed to the samples of the track. It is removed when
+// the track has no samples.
+func (tp *TrackPlan) trimEdits(start uint64, movieTimescale uint32) {
+	t := tp.Trak
+	if t.Edts == nil || t.Edts.Elst == nil {
+		return
+	}
+	elst := t.Edts.Elst
+	total := t.Mdia.Mdhd.Duration
+	if total == 0 {
+		t.Edts = nil
+		return
+	}
+	timescale := t.Mdia.Mdhd.Timescale
+	// the media edit must not start before the first composition time
+	first := int64(-1)
+	for _, c := range tp.Chunks {
+		for i := range c.Samples {
+			if ct := c.Samples[i].CompositionTime; first < 0 || ct < first {
+				first = ct
+			}
+		}
+	}
+	if first < 0 {
+		first = 0
+	}
+	mt, end := first, int64(total)
+	for i := range elst.MediaTime {
+		if elst.MediaTime[i] < 0 {
+			continue
+		}
+		// the first media edit, moved to the new media timeline
+		emt := elst.MediaTime[i] - int64(start)
+		eend := emt + int64(toUnits(toDuration(elst.SegmentDuration[i], movieTimescale), timescale))
+		if emt > mt && emt < end {
+			mt = emt
+		}
+		if elst.SegmentDuration[i] > 0 && eend > mt && eend < end {
+			end = eend
+		}
+		break
+	}
+	edits := &mp4.ElstBox{Version: elst.Version, Flags: elst.Flags}
+	if len(elst.MediaTime) > 1 && elst.MediaTime[0] == -1 && start == 0 {
+		edits.SegmentDuration = append(edits.SegmentDuration, elst.SegmentDuration[0])
+		edits.MediaTime = append(edits.MediaTime, -1)
+		edits.MediaRateInteger = append(edits.MediaRateInteger, 1)
+		edits.MediaRateFraction = append(edits.MediaRateFraction, 0)
+	}
+	edits.SegmentDuration = append(edits.SegmentDuration, toUnits(toDuration(uint64(end-mt), timescale), movieTimescale))
+	edits.MediaTime = append(edits.MediaTime, mt)
+	edits.MediaRateInteger = append(edits.MediaRateInteger, 1)
+	edits.MediaRateFraction = append(edits.MediaRateFraction, 0)
+	t.Edts.Elst = edits
+	t.Tkhd.Duration = 0
+	for _, d := range edits.SegmentDuration {
+		t.Tkhd.Duration += d
+	}
+}
+
+// renumber updates the numbers, chunks and times of the samples to match the rebuilt sample tables
+func (tp *TrackPlan) renumber() {
+	var n, chunk uint32
+	var dts uint64
+	for _, c := range tp.Chunks {
+		if len(c.Samples) == 0 {
+			continue
+		}
+		chunk++
+		for i := range c.Samples {
+			s := &c.Samples[i].Sample
+			n++
+			s.Number, s.Chunk = n, chunk
+			s.CompositionTime += int64(dts) - int64(s.DecodeTime)
+			s.DecodeTime = dts
+			dts += uint64(s.Duration)
+		}
+	}
+}
+
+func (f *planFilter) FilterMdat(w io.Writer, m *mp4.MdatBox) error {
+	defer f.closeTemp()
+	if f.err == nil && !f.changed && f.temp == nil {
+		// the size of the content may be unknown, see mp4.MdatBox.UnknownSize
+		return m.Encode(w)
+	}
+	if f.err == nil && !f.changed && f.spooled > 0 {
+		// the content was read by spool
+		err := mp4.EncodeHeader(m, w)
+		if err == nil {
+			_, err = io.Copy(w, io.NewSectionReader(f.temp, 0, f.spooled))
+		}
+		return err
+	}
+	src := &source{r: m.Reader(), ra: m.ReaderAt(), pos: uint64(m.Offset)}
+	if f.ra != nil {
+		// the source media, or its spooled mdat content
+		src.ra = f.ra
+	}
+	o := &OutputPlan{}
+	defer o.Close()
+	err := f.planMdat(o, m)
+	if err != nil {
+		return err
+	}
+	temp := &source{ra: o.Temp}
+	for _, p := range o.Parts {
+		switch {
+		case p.Data != nil:
+			_, err = w.Write(p.Data)
+		case p.Temp:
+			err = temp.copy(w, uint64(p.Offset), uint64(p.Length))
+		default:
+			err = src.copy(w, uint64(p.Offset), uint64(p.Length))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// planMdat adds the parts of the mdat box to o : its header, then the kept data (source ranges, in the order
+// of the output), the replaced data and the data stored in the temporary file of the filter (see Transform).
+// The temporary file is then owned by o.
+func (f *planFilter) planMdat(o *OutputPlan, m *mp4.MdatBox) error {
+	if f.err != nil {
+		return f.err
+	}
+	if f.temp != nil {
+		o.Temp, f.temp = f.temp.File, nil
+	}
+	if !f.changed {
+		err := o.addHeader(m)
+		if err != nil {
+			return err
+		}
+		o.addRange(m.Offset, int64(m.ContentSize), false)
+		return nil
+	}
+	prefix, err := f.prefix(m)
+	if err != nil {
+		return err
+	}
+	m.ContentSize = prefix + f.mdatSize
+	err = o.addHeader(m)
+	if err != nil {
+		return err
+	}
+	o.addRange(m.Offset, int64(prefix), false)
+	for _, c := range f.chunks {
+		for i := range c.Samples {
+			s := &c.Samples[i]
+			switch {
+			case s.Data != nil:
+				o.addData(s.Data)
+			case s.stored:
+				o.addRange(s.tempOffset, int64(s.Size), true)
+			default:
+				o.addRange(int64(s.Offset), int64(s.Size), false)
+			}
+		}
+	}
+	return nil
+}
+
+// prefix returns the size of the data kept before the first chunk
+func (f *planFilter) prefix(m *mp4.MdatBox) (uint64, error) {
+	if len(f.chunks) == 0 {
+		return 0, nil
+	}
+	if f.base < uint64(m.Offset) {
+		return 0, mp4.ErrBadFormat
+	}
+	return f.base - uint64(m.Offset), nil
+}
+
+func (f *planFilter) setSource(m *mp4.MP4) {
+	f.mdat = m.Mdat
+	f.ra = m.Mdat.ReaderAt()
+	f.mdats = []*mp4.MdatBox{m.Mdat}
+	for _, b := range m.Other {
+		if mdat, ok := b.(*mp4.MdatBox); ok {
+			f.mdats = append(f.mdats, mdat)
+		}
+	}
+}
+
+// readsData returns true when a planner reads or replaces sample data, see tempFile
+func (f *planFilter) readsData() bool {
+	for _, p := range f.planners {
+		if dp, ok := p.(dataPlanner); ok && dp.readsData() {
+			return true
+		}
+	}
+	return false
+}
+
+// spool copies the mdat content to the temporary file, so that planners can read sample data from media that
+// can not seek (see mp4.Decode)
+func (f *planFilter) spool() error {
+	n, err := io.Copy(f.temp, f.mdat.Reader())
+	if err != nil {
+		return err
+	}
+	f.temp.size, f.spooled = n, n
+	f.ra = &offsetReaderAt{ra: f.temp, offset: f.mdat.Offset}
+	return nil
+}
+
+// closeTemp removes the temporary file, unless it is owned by an OutputPlan (see planMdat)
+func (f *planFilter) closeTemp() {
+	if f.temp == nil {
+		return
+	}
+	f.temp.remove()
+	f.temp, f.ra, f.spooled = nil, nil, 0
+}
+
+// A tempFile holds the data of planners reading or replacing sample data : the spooled mdat content, at offset
+// 0, and the data stored by Plan.store, appended to it. It is removed once the mdat box is written.
+type tempFile struct {
+	*os.File
+	size int64
+}
+
+func newTempFile() (*tempFile, error) {
+	file, err := ioutil.TempFile("", "mp4-filter-")
+	if err != nil {
+		return nil, err
+	}
+	return &tempFile{File: file}, nil
+}
+
+// append writes data at the end of the file, and returns its offset
+func (t *tempFile) append(data []byte) (int64, error) {
+	offset := t.size
+	n, err := t.WriteAt(data, offset)
+	t.size += int64(n)
+	return offset, err
+}
+
+func (t *tempFile) remove() {
+	t.Close()
+	os.Remove(t.Name())
+}
+
+// offsetReaderAt reads data located at offset (absolute) from ra, at offset 0
+type offsetReaderAt struct {
+	ra     io.ReaderAt
+	offset int64
+}
+
+func (r *offsetReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < r.offset {
+		return 0, ErrTruncatedChunk
+	}
+	return r.ra.ReadAt(p, off-r.offset)
+}
+
+// dataPlanner is implemented by planners reading sample data (see Plan.ReadSample) or replacing it with data
+// stored in the temporary file of the filter
+type dataPlanner interface {
+	readsData() bool
+}
+
+// mergeMdat returns true when samples are packed in the first mdat box, i.e. when the plan changed
+func (f *planFilter) mergeMdat() bool {
+	return f.changed
+}
+
+func (f *planFilter) moovFirst() bool {
+	return f.faststart
+}
+
+// source reads the source media data, from its io.ReaderAt when available (samples may be located in other
+// mdat boxes), sequentially otherwise
+type source struct {
+	r   io.Reader
+	ra  io.ReaderAt
+	pos uint64
+}
+
+// copy copies n bytes located at offset (absolute) to w
+func (s *source) copy(w io.Writer, offset, n uint64) error {
+	var err error
+	switch {
+	case s.ra != nil:
+		_, err = io.CopyN(w, io.NewSectionReader(s.ra, int64(offset), int64(n)), int64(n))
+	case offset >= s.pos:
+		_, err = io.CopyN(ioutil.Discard, s.r, int64(offset-s.pos))
+		if err == nil {
+			_, err = io.CopyN(w, s.r, int64(n))
+		}
+		s.pos = offset + n
+	default:
+		return mp4.ErrNotSeekable
+	}
+	if err == io.EOF {
+		return ErrTruncatedChunk
+	}
+	return err
+}
@@ -0,0 +1,169 @@
+package filter
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jfbus/mp4"
+)
+
+func TestPlanMissingBoxes(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		remove func(m *mp4.MoovBox)
+	}{
+		{"mvhd", func(m *mp4.MoovBox) { m.Mvhd = nil }},
+		{"tkhd", func(m *mp4.MoovBox) { m.Trak[0].Tkhd = nil }},
+		{"mdhd", func(m *mp4.MoovBox) { m.Trak[0].Mdia.Mdhd = nil }},
+		{"minf", func(m *mp4.MoovBox) { m.Trak[0].Mdia.Minf = nil }},
+		{"stbl", func(m *mp4.MoovBox) { m.Trak[0].Mdia.Minf.Stbl = nil }},
+		{"mvhd timescale", func(m *mp4.MoovBox) { m.Mvhd.Timescale = 0 }},
+		{"mdhd timescale", func(m *mp4.MoovBox) { m.Trak[0].Mdia.Mdhd.Timescale = 0 }},
+	} {
+		for _, f := range []Filter{Noop(), Clip(1, 1), SelectTracks(TrackID(1))} {
+			m := decodeFile(t, "23976.mp4")
+			c.remove(m.Moov)
+			var buf bytes.Buffer
+			err := EncodeFiltered(&buf, m, f)
+			if err != mp4.ErrBadFormat {
+				t.Errorf("%s : expected ErrBadFormat, got %v", c.name, err)
+			}
+		}
+	}
+}
+
+// growPlanner adds 2GB to the size of the first sample of each chunk (the plan is not meant to be encoded)
+type growPlanner struct{}
+
+func (growPlanner) Plan(m *mp4.MoovBox, src *Plan) (*Plan, error) {
+	for _, tp := range src.Tracks {
+		for _, c := range tp.Chunks {
+			c.Samples[0].Size += 1 << 31
+		}
+	}
+	return src, nil
+}
+
+func TestPlanLargeMdat(t *testing.T) {
+	m := decodeFile(t, "23976.mp4")
+	o, err := EncodePlan(m, PlanFilter(growPlanner{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the plan is too large to be written : decode the boxes located before the mdat content
+	var head []byte
+	var offset int64
+	for _, p := range o.Parts {
+		if p.Data == nil {
+			offset += p.Length
+			continue
+		}
+		head = append(head, p.Data...)
+		offset += int64(len(p.Data))
+		if len(p.Data) == mp4.LargeBoxHeaderSize && string(p.Data[4:8]) == "mdat" {
+			break
+		}
+	}
+	out, err := mp4.Decode(bytes.NewReader(head))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if co := out.Moov.Trak[0].Mdia.Minf.Stbl.GetChunkOffset(1); co != uint64(offset) {
+		t.Fatalf("first chunk at offset %d, expected %d", co, offset)
+	}
+}
+
+func TestPlanReadOversized(t *testing.T) {
+	m := decodeFile(t, "23976.mp4")
+	keep := func(t *mp4.TrakBox, s mp4.Sample, data []byte) ([]byte, error) {
+		return nil, nil
+	}
+	// samples located beyond the end of the mdat box are not read
+	var buf bytes.Buffer
+	err := EncodeFiltered(&buf, m, PlanFilter(growPlanner{}, &transformFilter{fn: keep}))
+	if err != mp4.ErrBadFormat {
+		t.Fatalf("expected ErrBadFormat, got %v", err)
+	}
+}
+
+func TestPlanEdits(t *testing.T) {
+	edits := func(d ...int64) *mp4.ElstBox {
+		e := &mp4.ElstBox{}
+		for i := 0; i < len(d); i += 2 {
+			e.SegmentDuration = append(e.SegmentDuration, uint64(d[i]))
+			e.MediaTime = append(e.MediaTime, d[i+1])
+			e.MediaRateInteger = append(e.MediaRateInteger, 1)
+			e.MediaRateFraction = append(e.MediaRateFraction, 0)
+		}
+		return e
+	}
+	for _, c := range []struct {
+		name     string
+		f        Filter
+		src, dst *mp4.ElstBox
+	}{
+		// clip from the key frame at 500.5ms (media time 12012) to 2502.5ms : the edit starts before the clip
+		{"clip", Clip(1, 1), edits(3000, 2000), edits(2002, 0)},
+		// the start of the media is kept, and the edit ends before the clip end
+		{"clip start", ClipDuration(0, 3*time.Second), edits(200, -1, 1000, 24000), edits(200, -1, 1000, 24000)},
+		// the empty edit no longer applies
+		{"clip end", Clip(3, 1), edits(200, -1, 1000, 24000), edits(2002, 0)},
+	} {
+		m := decodeFile(t, "23976.mp4")
+		m.Moov.Trak[0].Edts.Elst = c.src
+		out := encodeFiltered(t, m, c.f)
+		elst := out.Moov.Trak[0].Edts.Elst
+		if !reflect.DeepEqual(elst.SegmentDuration, c.dst.SegmentDuration) || !reflect.DeepEqual(elst.MediaTime, c.dst.MediaTime) {
+			t.Errorf("%s : unexpected edit list %v %v", c.name, elst.SegmentDuration, elst.MediaTime)
+		}
+		var d uint64
+		for _, sd := range c.dst.SegmentDuration {
+			d += sd
+		}
+		if out.Moov.Trak[0].Tkhd.Duration != d || out.Moov.Mvhd.Duration != d {
+			t.Errorf("%s : unexpected durations %d %d", c.name, out.Moov.Trak[0].Tkhd.Duration, out.Moov.Mvhd.Duration)
+		}
+	}
+}
+
+func TestPlanSourceUnchanged(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/23976.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := decodeFile(t, "23976.mp4")
+	for _, f := range []func() Filter{
+		func() Filter { return ClipDuration(600*time.Millisecond, 500*time.Millisecond) },
+		func() Filter { return ClipExact(600*time.Millisecond, 500*time.Millisecond) },
+		func() Filter { return Chain(Clip(1, 1), Faststart()) },
+	} {
+		var first, second bytes.Buffer
+		if err = EncodeFiltered(&first, m, f()); err != nil {
+			t.Fatal(err)
+		}
+		o, err := EncodePlan(m, f())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = o.WriteTo(&second); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Fatal("the plan of the second encoding differs")
+		}
+		second.Reset()
+		if err = EncodeFiltered(&second, m, f()); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Fatalf("second encoding differs (size %d, expected %d)", second.Len(), first.Len())
+		}
+		var src bytes.Buffer
+		if err = m.Encode(&src); err != nil || !bytes.Equal(src.Bytes(), data) {
+			t.Fatalf("the source media was modified (%v)", err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package filter
+
+import (
+	"time"
+
+	"github.com/jfbus/mp4"
+)
+
+// sampleTables rebuilds the sample tables (stbl) of a track, chunk by chunk and sample by sample
+type sampleTables struct {
+	sizes      []uint32
+	durations  runs
+	ctsOffsets runs
+	sync       []uint32
+	allSync    bool
+	offsets    []uint64
+	stsc       mp4.StscBox
+	chunkSize  uint32 // samples in the current chunk
+	chunkDesc  uint32
+	units      uint64 // media duration
+}
+
+// runs is a run-length table (stts, ctts)
+type runs struct {
+	counts, values []uint32
+}
+
+func (r *runs) add(v uint32) {
+	if n := len(r.counts); n > 0 && r.values[n-1] == v {
+		r.counts[n-1]++
+		return
+	}
+	r.counts = append(r.counts, 1)
+	r.values = append(r.values, v)
+}
+
+func newSampleTables() *sampleTables {
+	return &sampleTables{allSync: true}
+}
+
+// addChunk starts a new chunk, at offset in the encoded media
+func (t *sampleTables) addChunk(offset uint64, descriptionID uint32) {
+	t.endChunk()
+	t.offsets = append(t.offsets, offset)
+	t.chunkDesc = descriptionID
+}
+
+// endChunk adds a stsc entry if the current chunk does not match the previous entry
+func (t *sampleTables) endChunk() {
+	if len(t.offsets) == 0 {
+		return
+	}
+	stsc := &t.stsc
+	n := len(stsc.FirstChunk)
+	if n == 0 || stsc.SamplesPerChunk[n-1] != t.chunkSize || stsc.SampleDescriptionID[n-1] != t.chunkDesc {
+		stsc.FirstChunk = append(stsc.FirstChunk, uint32(len(t.offsets)))
+		stsc.SamplesPerChunk = append(stsc.SamplesPerChunk, t.chunkSize)
+		stsc.SampleDescriptionID = append(stsc.SampleDescriptionID, t.chunkDesc)
+	}
+	t.chunkSize = 0
+}
+
+// addSample adds a sample (with the given size) to the current chunk
+func (t *sampleTables) addSample(s mp4.Sample, size uint32) {
+	t.sizes = append(t.sizes, size)
+	t.durations.add(s.Duration)
+	t.ctsOffsets.add(uint32(s.CompositionTime - int64(s.DecodeTime)))
+	if s.Sync {
+		t.sync = append(t.sync, uint32(len(t.sizes)))
+	} else {
+		t.allSync = false
+	}
+	t.units += uint64(s.Duration)
+	t.chunkSize++
+}
+
+// apply replaces the sample tables of trak, and updates the track durations (movieTimescale is the mvhd timescale)
+func (t *sampleTables) apply(trak *mp4.TrakBox, movieTimescale uint32) {
+	t.endChunk()
+	stbl := trak.Mdia.Minf.Stbl
+	if stbl.Stsc == nil {
+		stbl.Stsc = &mp4.StscBox{}
+	}
+	stbl.Stsc.FirstChunk, stbl.Stsc.SamplesPerChunk, stbl.Stsc.SampleDescriptionID = t.stsc.FirstChunk, t.stsc.SamplesPerChunk, t.stsc.SampleDescriptionID
+	if stbl.Stsc.FirstChunk == nil {
+		stbl.Stsc.FirstChunk, stbl.Stsc.SamplesPerChunk, stbl.Stsc.SampleDescriptionID = []uint32{}, []uint32{}, []uint32{}
+	}
+	if stbl.Stts == nil {
+		stbl.Stts = &mp4.SttsBox{}
+	}
+	stbl.Stts.SampleCount, stbl.Stts.SampleTimeDelta = nonNil(t.durations.counts), nonNil(t.durations.values)
+	// ctts is only needed when some samples have a composition offset
+	if len(t.ctsOffsets.values) == 0 || (len(t.ctsOffsets.values) == 1 && t.ctsOffsets.values[0] == 0) {
+		stbl.Ctts = nil
+	} else {
+		if stbl.Ctts == nil {
+			stbl.Ctts = &mp4.CttsBox{}
+		}
+		stbl.Ctts.SampleCount, stbl.Ctts.SampleOffset = t.ctsOffsets.counts, t.ctsOffsets.values
+		for _, v := range t.ctsOffsets.values {
+			if int32(v) < 0 {
+				stbl.Ctts.Version = 1
+			}
+		}
+	}
+	// stss is only needed when some samples are not sync samples
+	if !t.allSync || stbl.Stss != nil {
+		if stbl.Stss == nil {
+			stbl.Stss = &mp4.StssBox{}
+		}
+		stbl.Stss.SampleNumber = nonNil(t.sync)
+	}
+	stbl.SetSampleSizes(nonNil(t.sizes))
+	if t.offsets == nil {
+		t.offsets = []uint64{}
+	}
+	stbl.SetChunkOffsets(t.offsets)
+	trak.Mdia.Mdhd.Duration = t.units
+	trak.Tkhd.Duration = toUnits(toDuration(t.units, trak.Mdia.Mdhd.Timescale), movieTimescale)
+}
+
+func nonNil(l []uint32) []uint32 {
+	if l == nil {
+		return []uint32{}
+	}
+	return l
+}
+
+// toUnits converts a duration to time units
+func toUnits(d time.Duration, timescale uint32) uint64 {
+	ts := uint64(timescale)
+	return uint64(d/time.Second)*ts + uint64(d%time.Second)*ts/uint64(time.Second)
+}
+
+// toDuration converts time units to a duration
+func toDuration(units uint64, timescale uint32) time.Duration {
+	ts := uint64(timescale)
+	return time.Duration(units/ts)*time.Second + time.Duration(units%ts)*time.Second/time.Duration(ts)
+}
@@ -0,0 +1,100 @@
+package filter
+
+import (
+	"errors"
+
+	"github.com/jfbus/mp4"
+)
+
+var ErrNoTrack = errors.New("no track selected")
+
+// A TrackSelector matches tracks, index being the position of the track in the moov box
+type TrackSelector func(index int, t *mp4.TrakBox) bool
+
+// TrackIndex selects the track at the given position in the moov box (starting at 0)
+func TrackIndex(i int) TrackSelector {
+	return func(index int, t *mp4.TrakBox) bool {
+		return index == i
+	}
+}
+
+// TrackID selects the track with the given ID (tkhd)
+func TrackID(id uint32) TrackSelector {
+	return func(index int, t *mp4.TrakBox) bool {
+		return t.Tkhd != nil && t.Tkhd.TrackId == id
+	}
+}
+
+// TrackHandler selects tracks with the given handler type ("vide", "soun", ...)
+func TrackHandler(handlerType string) TrackSelector {
+	return func(index int, t *mp4.TrakBox) bool {
+		return t.Mdia != nil && t.Mdia.Hdlr != nil && t.Mdia.Hdlr.HandlerType == handlerType
+	}
+}
+
+// TrackLanguage selects tracks with the given ISO-639-2/T language code ("eng", "fra", ...)
+func TrackLanguage(lang string) TrackSelector {
+	return func(index int, t *mp4.TrakBox) bool {
+		return t.Mdia != nil && t.Mdia.Mdhd != nil && t.Mdia.Mdhd.LanguageCode() == lang
+	}
+}
+
+type trackFilter struct {
+	selectors []TrackSelector
+	drop      bool
+}
+
+// SelectTracks returns a filter that keeps the tracks matching the selectors, in the order of the selectors
+// (e.g. SelectTracks(TrackHandler("soun")) for an audio only media).
+func SelectTracks(selectors ...TrackSelector) Filter {
+	return PlanFilter(&trackFilter{selectors: selectors})
+}
+
+// DropTracks returns a filter that removes the tracks matching any of the selectors
+func DropTracks(selectors ...TrackSelector) Filter {
+	return PlanFilter(&trackFilter{selectors: selectors, drop: true})
+}
+
+// selected returns the indexes of the kept tracks, in their new order
+func (f *trackFilter) selected(m *mp4.MoovBox) []int {
+	l := []int{}
+	if f.drop {
+		for i, t := range m.Trak {
+			match := false
+			for _, sel := range f.selectors {
+				match = match || sel(i, t)
+			}
+			if !match {
+				l = append(l, i)
+			}
+		}
+		return l
+	}
+	used := make([]bool, len(m.Trak))
+	for _, sel := range f.selectors {
+		for i, t := range m.Trak {
+			if !used[i] && sel(i, t) {
+				used[i] = true
+				l = append(l, i)
+			}
+		}
+	}
+	return l
+}
+
+func (f *trackFilter) Plan(m *mp4.MoovBox, src *Plan) (*Plan, error) {
+	kept := f.selected(m)
+	if len(kept) == 0 {
+		return nil, ErrNoTrack
+	}
+	p := &Plan{}
+	m.Mvhd.NextTrackId = 1
+	for _, i := range kept {
+		tp := src.Tracks[i]
+		p.Tracks = append(p.Tracks, tp)
+		if tp.Trak.Tkhd.TrackId >= m.Mvhd.NextTrackId {
+			m.Mvhd.NextTrackId = tp.Trak.Tkhd.TrackId + 1
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,109 @@
+package filter
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/jfbus/mp4"
+)
+
+// checkSamples checks that the track id of out holds the samples of the track of src, with the same data
+func checkSamples(t *testing.T, name string, src, out *mp4.MP4, id uint32) {
+	st, ot := src.Track(id), out.Track(id)
+	if ot == nil {
+		t.Fatalf("%s : track %d not found", name, id)
+	}
+	sit, oit := st.Samples(), ot.Samples()
+	for {
+		s, err := sit.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		o, err := oit.Next()
+		if err != nil {
+			t.Fatalf("%s : track %d : sample %d : %v", name, id, s.Number, err)
+		}
+		if o.Number != s.Number || o.Size != s.Size || o.DecodeTime != s.DecodeTime || o.Sync != s.Sync {
+			t.Fatalf("%s : track %d : sample %d differs : %+v, expected %+v", name, id, s.Number, o, s)
+		}
+		expected, err := src.ReadSample(id, int(s.Number))
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := out.ReadSample(id, int(o.Number))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected, actual) {
+			t.Fatalf("%s : track %d : data of sample %d differs", name, id, s.Number)
+		}
+	}
+	if _, err := oit.Next(); err != io.EOF {
+		t.Fatalf("%s : track %d has more samples than the source", name, id)
+	}
+}
+
+// av.mp4 has a video track (ID 1, "und", 5005ms) and an audio track (ID 2, "eng", 5013ms), with interleaved
+// chunks
+func TestTracks(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		f        Filter
+		ids      []uint32
+		duration uint64
+	}{
+		{"video", SelectTracks(TrackHandler("vide")), []uint32{1}, 5005},
+		{"audio", SelectTracks(TrackHandler("soun")), []uint32{2}, 5013},
+		{"language", SelectTracks(TrackLanguage("eng")), []uint32{2}, 5013},
+		{"index", SelectTracks(TrackIndex(0)), []uint32{1}, 5005},
+		{"reorder", SelectTracks(TrackID(2), TrackID(1)), []uint32{2, 1}, 5013},
+		{"all", SelectTracks(TrackHandler("vide"), TrackHandler("soun")), []uint32{1, 2}, 5013},
+		{"drop video", DropTracks(TrackIndex(0)), []uint32{2}, 5013},
+		{"drop audio", DropTracks(TrackLanguage("eng"), TrackID(5)), []uint32{1}, 5005},
+	} {
+		src := decodeFile(t, "av.mp4")
+		out := encodeFiltered(t, decodeFile(t, "av.mp4"), c.f)
+		if len(out.Moov.Trak) != len(c.ids) {
+			t.Fatalf("%s : %d tracks, expected %d", c.name, len(out.Moov.Trak), len(c.ids))
+		}
+		var size, next uint64
+		for i, id := range c.ids {
+			if out.Moov.Trak[i].Tkhd.TrackId != id {
+				t.Fatalf("%s : track %d has ID %d, expected %d", c.name, i, out.Moov.Trak[i].Tkhd.TrackId, id)
+			}
+			checkSamples(t, c.name, src, out, id)
+			stbl := out.Moov.Trak[i].Mdia.Minf.Stbl
+			for n := 1; n <= stbl.SampleCount(); n++ {
+				size += uint64(stbl.GetSampleSize(n))
+			}
+			if uint64(id) >= next {
+				next = uint64(id) + 1
+			}
+		}
+		if out.Mdat.ContentSize != size {
+			t.Errorf("%s : mdat content size %d, expected %d", c.name, out.Mdat.ContentSize, size)
+		}
+		if uint64(out.Moov.Mvhd.NextTrackId) != next {
+			t.Errorf("%s : next track ID %d, expected %d", c.name, out.Moov.Mvhd.NextTrackId, next)
+		}
+		if out.Moov.Mvhd.Duration != c.duration {
+			t.Errorf("%s : duration %d, expected %d", c.name, out.Moov.Mvhd.Duration, c.duration)
+		}
+	}
+
+	for _, f := range []Filter{
+		SelectTracks(TrackLanguage("fra")),
+		SelectTracks(),
+		DropTracks(TrackHandler("vide"), TrackHandler("soun")),
+	} {
+		var buf bytes.Buffer
+		err := EncodeFiltered(&buf, decodeFile(t, "av.mp4"), f)
+		if err != ErrNoTrack {
+			t.Errorf("expected ErrNoTrack, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package filter
+
+import "github.com/jfbus/mp4"
+
+// A SampleTransform returns the new data of a sample of track t, data being its current data. Sample times
+// are in the track timescale (mdhd). Returning nil keeps the data unchanged.
+type SampleTransform func(t *mp4.TrakBox, s mp4.Sample, data []byte) ([]byte, error)
+
+type transformFilter struct {
+	fn SampleTransform
+}
+
+// Transform returns a filter that rewrites the data of each sample (e.g. to strip NAL units), replaced data
+// can have any size.
+//
+// fn is called once per sample, when planning. Replaced data is not kept in memory : it is written to a temporary
+// file, and copied from it when the mdat box is written. When the source media was not decoded from a io.ReaderAt
+// (see mp4.Decode), its mdat content is also copied to the temporary file. The file is removed once the mdat box
+// is written, or by OutputPlan.Close (see EncodePlan).
+func Transform(fn SampleTransform) Filter {
+	return PlanFilter(&transformFilter{fn: fn})
+}
+
+func (f *transformFilter) Plan(m *mp4.MoovBox, src *Plan) (*Plan, error) {
+	for _, tp := range src.Tracks {
+		for _, c := range tp.Chunks {
+			for i := range c.Samples {
+				s := &c.Samples[i]
+				data, err := src.ReadSample(s)
+				if err != nil {
+					return nil, err
+				}
+				data, err = f.fn(tp.Trak, s.Sample, data)
+				if err != nil {
+					return nil, err
+				}
+				if data != nil {
+					err = src.store(s, data)
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+	return src, nil
+}
+
+func (f *transformFilter) readsData() bool {
+	return true
+}
@@ -0,0 +1,94 @@
+package filter
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/jfbus/mp4"
+)
+
+func TestTransform(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/23976.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// strips the first 2 bytes of even samples
+	strip := func(tr *mp4.TrakBox, s mp4.Sample, data []byte) ([]byte, error) {
+		if s.Number%2 == 0 {
+			return data[2:], nil
+		}
+		return nil, nil
+	}
+	src := decodeFile(t, "23976.mp4")
+	// mp4.Decode : the mdat content is spooled to a temporary file
+	m, err := mp4.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*mp4.MP4{decodeFile(t, "23976.mp4"), m} {
+		out := encodeFiltered(t, m, Transform(strip))
+		for n := 1; n <= src.Moov.Trak[0].Mdia.Minf.Stbl.SampleCount(); n++ {
+			expected, err := src.ReadSample(1, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n%2 == 0 {
+				expected = expected[2:]
+			}
+			actual, err := out.ReadSample(1, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(expected, actual) {
+				t.Fatalf("sample %d was not transformed", n)
+			}
+		}
+	}
+
+	// the callback is called once per sample, with EncodeFiltered and EncodePlan
+	calls := 0
+	random := func(tr *mp4.TrakBox, s mp4.Sample, data []byte) ([]byte, error) {
+		calls++
+		return make([]byte, calls), nil
+	}
+	count := src.Moov.Trak[0].Mdia.Minf.Stbl.SampleCount()
+	var buf bytes.Buffer
+	err = EncodeFiltered(&buf, decodeFile(t, "23976.mp4"), Transform(random))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != count {
+		t.Fatalf("expected %d calls, got %d", count, calls)
+	}
+	calls = 0
+	o, err := EncodePlan(decodeFile(t, "23976.mp4"), Transform(random))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Temp == nil {
+		t.Fatal("expected a temporary file")
+	}
+	for i := 0; i < 2; i++ {
+		var out bytes.Buffer
+		_, err = o.WriteTo(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), buf.Bytes()) {
+			t.Fatal("plan and filtered media differ")
+		}
+	}
+	if calls != count {
+		t.Fatalf("expected %d calls, got %d", count, calls)
+	}
+	name := o.Temp.Name()
+	err = o.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Fatalf("temporary file was not removed : %v", err)
+	}
+}
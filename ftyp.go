@@ -10,6 +10,7 @@ import (
 //
 // Status: decoded
 type FtypBox struct {
+	header
 	MajorBrand       string
 	MinorVersion     []byte
 	CompatibleBrands []string
@@ -20,13 +21,16 @@ func DecodeFtyp(r io.Reader) (Box, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(data) < 8 {
+		return nil, ErrBadFormat
+	}
 	b := &FtypBox{
 		MajorBrand:       string(data[0:4]),
 		MinorVersion:     data[4:8],
 		CompatibleBrands: []string{},
 	}
 	if len(data) > 8 {
-		for i := 8; i < len(data); i += 4 {
+		for i := 8; i+4 <= len(data); i += 4 {
 			b.CompatibleBrands = append(b.CompatibleBrands, string(data[i:i+4]))
 		}
 	}
@@ -37,8 +41,8 @@ func (b *FtypBox) Type() string {
 	return "ftyp"
 }
 
-func (b *FtypBox) Size() int {
-	return BoxHeaderSize + 8 + 4*len(b.CompatibleBrands)
+func (b *FtypBox) Size() uint64 {
+	return b.boxSize(8 + 4*uint64(len(b.CompatibleBrands)))
 }
 
 func (b *FtypBox) Dump() {
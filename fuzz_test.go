@@ -0,0 +1,132 @@
+package mp4
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// maxFuzzSamples limits the number of samples read from fuzzed media (sample tables may describe billions of
+// samples)
+const maxFuzzSamples = 1000
+
+func addFixtures(f *testing.F) {
+	for _, name := range []string{"moov_first.mp4", "moov_last.mp4", "unknown_boxes.mp4", "large_header.mp4"} {
+		f.Add(readFile(f, name))
+	}
+}
+
+// checkMedia encodes a decoded media and reads its samples : errors are expected, panics are not
+func checkMedia(m *MP4) {
+	m.Encode(ioutil.Discard)
+	it := m.Samples()
+	count := 0
+	for ; count < maxFuzzSamples; count++ {
+		s, err := it.Next()
+		if err != nil {
+			break
+		}
+		m.ReadSample(s.Track, int(s.Number))
+	}
+	if count < maxFuzzSamples {
+		NewSeekIndex(m)
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	addFixtures(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, err := Decode(bytes.NewReader(data))
+		if err == nil {
+			checkMedia(m)
+		}
+	})
+}
+
+func FuzzDecodeAt(f *testing.F) {
+	addFixtures(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, err := DecodeAt(bytes.NewReader(data), int64(len(data)))
+		if err == nil {
+			checkMedia(m)
+		}
+	})
+}
+
+// fuzzBox decodes the content of a box of type typ. A decoded box must be encoded, and decoding the encoded box
+// must give the same box.
+func fuzzBox(f *testing.F, typ string) {
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h := BoxHeader{Type: typ, Size: uint64(len(data)) + BoxHeaderSize, HeaderSize: BoxHeaderSize}
+		b, err := decodeBox(h, bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		var buf bytes.Buffer
+		err = b.Encode(&buf)
+		if err != nil {
+			t.Fatalf("%s : unable to encode decoded box : %s", typ, err)
+		}
+		if uint64(buf.Len()) != b.Size() {
+			t.Fatalf("%s : encoded size %d differs from box size %d", typ, buf.Len(), b.Size())
+		}
+		encoded := buf.Bytes()
+		r := bytes.NewReader(encoded)
+		h, err = DecodeHeader(r)
+		if err != nil {
+			t.Fatalf("%s : unable to decode encoded header : %s", typ, err)
+		}
+		b, err = decodeBox(h, r)
+		if err != nil {
+			t.Fatalf("%s : unable to decode encoded box : %s", typ, err)
+		}
+		buf = bytes.Buffer{}
+		err = b.Encode(&buf)
+		if err != nil {
+			t.Fatalf("%s : unable to encode decoded box : %s", typ, err)
+		}
+		if !bytes.Equal(encoded, buf.Bytes()) {
+			t.Fatalf("%s : box changed after decoding and encoding again", typ)
+		}
+	})
+}
+
+// decodeBox decodes a box, setting the content size of mdat boxes as Decode does
+func decodeBox(h BoxHeader, r io.Reader) (Box, error) {
+	b, err := DecodeBox(h, r)
+	if mdat, ok := b.(*MdatBox); ok {
+		mdat.ContentSize = h.Size - h.HeaderSize
+	}
+	return b, err
+}
+
+func FuzzDecodeFtyp(f *testing.F) { fuzzBox(f, "ftyp") }
+func FuzzDecodeMoov(f *testing.F) { fuzzBox(f, "moov") }
+func FuzzDecodeMvhd(f *testing.F) { fuzzBox(f, "mvhd") }
+func FuzzDecodeIods(f *testing.F) { fuzzBox(f, "iods") }
+func FuzzDecodeTrak(f *testing.F) { fuzzBox(f, "trak") }
+func FuzzDecodeUdta(f *testing.F) { fuzzBox(f, "udta") }
+func FuzzDecodeTkhd(f *testing.F) { fuzzBox(f, "tkhd") }
+func FuzzDecodeEdts(f *testing.F) { fuzzBox(f, "edts") }
+func FuzzDecodeElst(f *testing.F) { fuzzBox(f, "elst") }
+func FuzzDecodeMdia(f *testing.F) { fuzzBox(f, "mdia") }
+func FuzzDecodeMinf(f *testing.F) { fuzzBox(f, "minf") }
+func FuzzDecodeMdhd(f *testing.F) { fuzzBox(f, "mdhd") }
+func FuzzDecodeHdlr(f *testing.F) { fuzzBox(f, "hdlr") }
+func FuzzDecodeVmhd(f *testing.F) { fuzzBox(f, "vmhd") }
+func FuzzDecodeSmhd(f *testing.F) { fuzzBox(f, "smhd") }
+func FuzzDecodeDinf(f *testing.F) { fuzzBox(f, "dinf") }
+func FuzzDecodeDref(f *testing.F) { fuzzBox(f, "dref") }
+func FuzzDecodeStbl(f *testing.F) { fuzzBox(f, "stbl") }
+func FuzzDecodeStco(f *testing.F) { fuzzBox(f, "stco") }
+func FuzzDecodeCo64(f *testing.F) { fuzzBox(f, "co64") }
+func FuzzDecodeStsc(f *testing.F) { fuzzBox(f, "stsc") }
+func FuzzDecodeStsz(f *testing.F) { fuzzBox(f, "stsz") }
+func FuzzDecodeStz2(f *testing.F) { fuzzBox(f, "stz2") }
+func FuzzDecodeCtts(f *testing.F) { fuzzBox(f, "ctts") }
+func FuzzDecodeStsd(f *testing.F) { fuzzBox(f, "stsd") }
+func FuzzDecodeStts(f *testing.F) { fuzzBox(f, "stts") }
+func FuzzDecodeStss(f *testing.F) { fuzzBox(f, "stss") }
+func FuzzDecodeMeta(f *testing.F) { fuzzBox(f, "meta") }
+func FuzzDecodeMdat(f *testing.F) { fuzzBox(f, "mdat") }
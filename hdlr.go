@@ -16,11 +16,13 @@ import (
 //
 // HandlerType can be : "vide" (video track), "soun" (audio track), "hint" (hint track), "meta" (timed Metadata track), "auxv" (auxiliary video track).
 type HdlrBox struct {
+	header
 	Version     byte
 	Flags       [3]byte
 	PreDefined  uint32
 	HandlerType string
 	Name        string
+	reserved    []byte
 }
 
 func DecodeHdlr(r io.Reader) (Box, error) {
@@ -28,11 +30,15 @@ func DecodeHdlr(r io.Reader) (Box, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(data) < 24 {
+		return nil, ErrBadFormat
+	}
 	return &HdlrBox{
 		Version:     data[0],
 		Flags:       [3]byte{data[1], data[2], data[3]},
 		PreDefined:  binary.BigEndian.Uint32(data[4:8]),
 		HandlerType: string(data[8:12]),
+		reserved:    data[12:24],
 		Name:        string(data[24:]),
 	}, nil
 }
@@ -41,8 +47,8 @@ func (b *HdlrBox) Type() string {
 	return "hdlr"
 }
 
-func (b *HdlrBox) Size() int {
-	return BoxHeaderSize + 24 + len(b.Name)
+func (b *HdlrBox) Size() uint64 {
+	return b.boxSize(24 + uint64(len(b.Name)))
 }
 
 func (b *HdlrBox) Encode(w io.Writer) error {
@@ -55,6 +61,7 @@ func (b *HdlrBox) Encode(w io.Writer) error {
 	buf[1], buf[2], buf[3] = b.Flags[0], b.Flags[1], b.Flags[2]
 	binary.BigEndian.PutUint32(buf[4:], b.PreDefined)
 	strtobuf(buf[8:], b.HandlerType, 4)
+	copy(buf[12:], b.reserved)
 	strtobuf(buf[24:], b.Name, len(b.Name))
 	_, err = w.Write(buf)
 	return err
@@ -11,6 +11,7 @@ import (
 //
 // Status: not decoded
 type IodsBox struct {
+	header
 	notDecoded []byte
 }
 
@@ -28,8 +29,8 @@ func (b *IodsBox) Type() string {
 	return "iods"
 }
 
-func (b *IodsBox) Size() int {
-	return BoxHeaderSize + len(b.notDecoded)
+func (b *IodsBox) Size() uint64 {
+	return b.boxSize(uint64(len(b.notDecoded)))
 }
 
 func (b *IodsBox) Encode(w io.Writer) error {
@@ -9,9 +9,23 @@ import "io"
 // The mdat box contains media chunks/samples.
 //
 // It is not read, only the io.Reader is stored, and will be used to Encode (io.Copy) the box to a io.Writer.
+// Offset is the absolute offset of the content in the decoded file, chunk offsets (stco/co64) point
+// inside it.
+//
+// When decoded from a io.ReaderAt, the content can be read several times, concurrently (each call to Reader
+// returns a new reader).
+//
+// A large (64 bits) header is used when the content does not fit in 4GB, or when the box was decoded
+// with a large header. A box decoded with a size of 0 (extending to the end of the file) is encoded with
+// a size of 0, and must remain the last box.
 type MdatBox struct {
-	ContentSize uint32
+	header
+	ContentSize uint64
+	Offset      int64
 	r           io.Reader
+	ra          io.ReaderAt
+	sizeZero    bool
+	unknownSize bool
 }
 
 func DecodeMdat(r io.Reader) (Box, error) {
@@ -22,19 +36,67 @@ func (b *MdatBox) Type() string {
 	return "mdat"
 }
 
-func (b *MdatBox) Size() int {
-	return BoxHeaderSize + int(b.ContentSize)
+func (b *MdatBox) Size() uint64 {
+	return b.boxSize(b.ContentSize)
+}
+
+func (b *MdatBox) toEOF() bool {
+	return b.sizeZero
+}
+
+// UnknownSize returns true when the box extends to the end of the file and was decoded from a reader that
+// cannot seek (see Decode) : ContentSize is then 0, and the content is read until EOF.
+func (b *MdatBox) UnknownSize() bool {
+	return b.unknownSize
 }
 
 func (b *MdatBox) Reader() io.Reader {
+	if b.ra != nil {
+		return io.NewSectionReader(b.ra, b.Offset, int64(b.ContentSize))
+	}
 	return b.r
 }
 
+// Contains returns true when the size bytes located at offset (absolute) are in the content of the box. The
+// content of a box of unknown size (see UnknownSize) extends to the end of the file.
+func (b *MdatBox) Contains(offset, size uint64) bool {
+	if offset < uint64(b.Offset) {
+		return false
+	}
+	return b.unknownSize || offset-uint64(b.Offset) <= b.ContentSize && size <= b.ContentSize-(offset-uint64(b.Offset))
+}
+
+// ReaderAt returns the io.ReaderAt the box was decoded from (offsets are absolute, see Offset), or nil when
+// the content can only be read once (see Reader).
+func (b *MdatBox) ReaderAt() io.ReaderAt {
+	return b.ra
+}
+
 func (b *MdatBox) Encode(w io.Writer) error {
 	err := EncodeHeader(b, w)
 	if err != nil {
 		return err
 	}
-	_, err = io.Copy(w, b.r)
+	_, err = io.Copy(w, b.Reader())
 	return err
 }
+
+// seekReader reads n bytes from offset in rs, seeking on the first read : rs may be used to decode
+// other boxes in the meantime.
+type seekReader struct {
+	rs     io.ReadSeeker
+	offset int64
+	n      int64
+	r      io.Reader
+}
+
+func (s *seekReader) Read(p []byte) (int, error) {
+	if s.r == nil {
+		_, err := s.rs.Seek(s.offset, io.SeekStart)
+		if err != nil {
+			return 0, err
+		}
+		s.r = io.LimitReader(s.rs, s.n)
+	}
+	return s.r.Read(p)
+}
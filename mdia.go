@@ -9,10 +9,15 @@ import "io"
 // Status: decoded
 //
 // Contains all information about the media data.
+//
+// Boxes other than mdhd, hdlr and minf are stored in Other.
 type MdiaBox struct {
-	Mdhd *MdhdBox
-	Hdlr *HdlrBox
-	Minf *MinfBox
+	header
+	Mdhd  *MdhdBox
+	Hdlr  *HdlrBox
+	Minf  *MinfBox
+	Other []Box
+	order []string
 }
 
 func DecodeMdia(r io.Reader) (Box, error) {
@@ -20,17 +25,17 @@ func DecodeMdia(r io.Reader) (Box, error) {
 	if err != nil {
 		return nil, err
 	}
-	m := &MdiaBox{}
+	m := &MdiaBox{order: boxTypes(l)}
 	for _, b := range l {
-		switch b.Type() {
-		case "mdhd":
-			m.Mdhd = b.(*MdhdBox)
-		case "hdlr":
-			m.Hdlr = b.(*HdlrBox)
-		case "minf":
-			m.Minf = b.(*MinfBox)
+		switch c := b.(type) {
+		case *MdhdBox:
+			m.Mdhd = c
+		case *HdlrBox:
+			m.Hdlr = c
+		case *MinfBox:
+			m.Minf = c
 		default:
-			return nil, ErrBadFormat
+			m.Other = append(m.Other, b)
 		}
 	}
 	return m, nil
@@ -40,15 +45,23 @@ func (b *MdiaBox) Type() string {
 	return "mdia"
 }
 
-func (b *MdiaBox) Size() int {
-	sz := b.Mdhd.Size()
+// children returns the child boxes, in the default encoding order
+func (b *MdiaBox) children() []Box {
+	l := []Box{}
+	if b.Mdhd != nil {
+		l = append(l, b.Mdhd)
+	}
 	if b.Hdlr != nil {
-		sz += b.Hdlr.Size()
+		l = append(l, b.Hdlr)
 	}
 	if b.Minf != nil {
-		sz += b.Minf.Size()
+		l = append(l, b.Minf)
 	}
-	return sz + BoxHeaderSize
+	return append(l, b.Other...)
+}
+
+func (b *MdiaBox) Size() uint64 {
+	return b.boxSize(childrenSize(b.children()))
 }
 
 func (b *MdiaBox) Dump() {
@@ -63,15 +76,5 @@ func (b *MdiaBox) Encode(w io.Writer) error {
 	if err != nil {
 		return err
 	}
-	err = b.Mdhd.Encode(w)
-	if err != nil {
-		return err
-	}
-	if b.Hdlr != nil {
-		err = b.Hdlr.Encode(w)
-		if err != nil {
-			return err
-		}
-	}
-	return b.Minf.Encode(w)
+	return encodeOrdered(w, b.order, b.children())
 }
@@ -9,6 +9,7 @@ import (
 //
 // Status: not decoded
 type MetaBox struct {
+	header
 	Version    byte
 	Flags      [3]byte
 	notDecoded []byte
@@ -19,6 +20,9 @@ func DecodeMeta(r io.Reader) (Box, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(data) < 4 {
+		return nil, ErrBadFormat
+	}
 	return &MetaBox{
 		Version:    data[0],
 		Flags:      [3]byte{data[1], data[2], data[3]},
@@ -30,8 +34,8 @@ func (b *MetaBox) Type() string {
 	return "meta"
 }
 
-func (b *MetaBox) Size() int {
-	return BoxHeaderSize + 4 + len(b.notDecoded)
+func (b *MetaBox) Size() uint64 {
+	return b.boxSize(4 + uint64(len(b.notDecoded)))
 }
 
 func (b *MetaBox) Encode(w io.Writer) error {
@@ -6,13 +6,16 @@ import "io"
 //
 // Contained in : Media Box (mdia)
 //
-// Status: partially decoded (hmhd - hint tracks - and nmhd - null media - are ignored)
+// Status: partially decoded (hmhd - hint tracks -, nmhd - null media - and other boxes are stored in Other)
 type MinfBox struct {
-	Vmhd *VmhdBox
-	Smhd *SmhdBox
-	Stbl *StblBox
-	Dinf *DinfBox
-	Hdlr *HdlrBox
+	header
+	Vmhd  *VmhdBox
+	Smhd  *SmhdBox
+	Stbl  *StblBox
+	Dinf  *DinfBox
+	Hdlr  *HdlrBox
+	Other []Box
+	order []string
 }
 
 func DecodeMinf(r io.Reader) (Box, error) {
@@ -20,19 +23,21 @@ func DecodeMinf(r io.Reader) (Box, error) {
 	if err != nil {
 		return nil, err
 	}
-	m := &MinfBox{}
+	m := &MinfBox{order: boxTypes(l)}
 	for _, b := range l {
-		switch b.Type() {
-		case "vmhd":
-			m.Vmhd = b.(*VmhdBox)
-		case "smhd":
-			m.Smhd = b.(*SmhdBox)
-		case "stbl":
-			m.Stbl = b.(*StblBox)
-		case "dinf":
-			m.Dinf = b.(*DinfBox)
-		case "hdlr":
-			m.Hdlr = b.(*HdlrBox)
+		switch c := b.(type) {
+		case *VmhdBox:
+			m.Vmhd = c
+		case *SmhdBox:
+			m.Smhd = c
+		case *StblBox:
+			m.Stbl = c
+		case *DinfBox:
+			m.Dinf = c
+		case *HdlrBox:
+			m.Hdlr = c
+		default:
+			m.Other = append(m.Other, b)
 		}
 	}
 	return m, nil
@@ -42,22 +47,29 @@ func (b *MinfBox) Type() string {
 	return "minf"
 }
 
-func (b *MinfBox) Size() int {
-	sz := 0
+// children returns the child boxes, in the default encoding order
+func (b *MinfBox) children() []Box {
+	l := []Box{}
 	if b.Vmhd != nil {
-		sz += b.Vmhd.Size()
+		l = append(l, b.Vmhd)
 	}
 	if b.Smhd != nil {
-		sz += b.Smhd.Size()
+		l = append(l, b.Smhd)
 	}
-	sz += b.Stbl.Size()
 	if b.Dinf != nil {
-		sz += b.Dinf.Size()
+		l = append(l, b.Dinf)
+	}
+	if b.Stbl != nil {
+		l = append(l, b.Stbl)
 	}
 	if b.Hdlr != nil {
-		sz += b.Hdlr.Size()
+		l = append(l, b.Hdlr)
 	}
-	return sz + BoxHeaderSize
+	return append(l, b.Other...)
+}
+
+func (b *MinfBox) Size() uint64 {
+	return b.boxSize(childrenSize(b.children()))
 }
 
 func (b *MinfBox) Dump() {
@@ -69,28 +81,5 @@ func (b *MinfBox) Encode(w io.Writer) error {
 	if err != nil {
 		return err
 	}
-	if b.Vmhd != nil {
-		err = b.Vmhd.Encode(w)
-		if err != nil {
-			return err
-		}
-	}
-	if b.Smhd != nil {
-		err = b.Smhd.Encode(w)
-		if err != nil {
-			return err
-		}
-	}
-	err = b.Dinf.Encode(w)
-	if err != nil {
-		return err
-	}
-	err = b.Stbl.Encode(w)
-	if err != nil {
-		return err
-	}
-	if b.Hdlr != nil {
-		return b.Hdlr.Encode(w)
-	}
-	return nil
+	return encodeOrdered(w, b.order, b.children())
 }
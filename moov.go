@@ -7,14 +7,17 @@ import (
 
 // Movie Box (moov - mandatory)
 //
-// Status: partially decoded (anything other than mvhd, iods, trak or udta is ignored)
+// Status: partially decoded (anything other than mvhd, iods, trak or udta is stored in Other)
 //
 // Contains all meta-data. To be able to stream a file, the moov box should be placed before the mdat box.
 type MoovBox struct {
-	Mvhd *MvhdBox
-	Iods *IodsBox
-	Trak []*TrakBox
-	Udta *UdtaBox
+	header
+	Mvhd  *MvhdBox
+	Iods  *IodsBox
+	Trak  []*TrakBox
+	Udta  *UdtaBox
+	Other []Box
+	order []string
 }
 
 func DecodeMoov(r io.Reader) (Box, error) {
@@ -22,17 +25,19 @@ func DecodeMoov(r io.Reader) (Box, error) {
 	if err != nil {
 		return nil, err
 	}
-	m := &MoovBox{}
+	m := &MoovBox{order: boxTypes(l)}
 	for _, b := range l {
-		switch b.Type() {
-		case "mvhd":
-			m.Mvhd = b.(*MvhdBox)
-		case "iods":
-			m.Iods = b.(*IodsBox)
-		case "trak":
-			m.Trak = append(m.Trak, b.(*TrakBox))
-		case "udta":
-			m.Udta = b.(*UdtaBox)
+		switch c := b.(type) {
+		case *MvhdBox:
+			m.Mvhd = c
+		case *IodsBox:
+			m.Iods = c
+		case *TrakBox:
+			m.Trak = append(m.Trak, c)
+		case *UdtaBox:
+			m.Udta = c
+		default:
+			m.Other = append(m.Other, b)
 		}
 	}
 	return m, err
@@ -42,18 +47,26 @@ func (b *MoovBox) Type() string {
 	return "moov"
 }
 
-func (b *MoovBox) Size() int {
-	sz := b.Mvhd.Size()
+// children returns the child boxes, in the default encoding order
+func (b *MoovBox) children() []Box {
+	l := []Box{}
+	if b.Mvhd != nil {
+		l = append(l, b.Mvhd)
+	}
 	if b.Iods != nil {
-		sz += b.Iods.Size()
+		l = append(l, b.Iods)
 	}
 	for _, t := range b.Trak {
-		sz += t.Size()
+		l = append(l, t)
 	}
 	if b.Udta != nil {
-		sz += b.Udta.Size()
+		l = append(l, b.Udta)
 	}
-	return sz + BoxHeaderSize
+	return append(l, b.Other...)
+}
+
+func (b *MoovBox) Size() uint64 {
+	return b.boxSize(childrenSize(b.children()))
 }
 
 func (b *MoovBox) Dump() {
@@ -69,24 +82,5 @@ func (b *MoovBox) Encode(w io.Writer) error {
 	if err != nil {
 		return err
 	}
-	err = b.Mvhd.Encode(w)
-	if err != nil {
-		return err
-	}
-	if b.Iods != nil {
-		err = b.Iods.Encode(w)
-		if err != nil {
-			return err
-		}
-	}
-	for _, t := range b.Trak {
-		err = t.Encode(w)
-		if err != nil {
-			return err
-		}
-	}
-	if b.Udta != nil {
-		return b.Udta.Encode(w)
-	}
-	return nil
+	return encodeOrdered(w, b.order, b.children())
 }
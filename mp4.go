@@ -10,51 +10,93 @@ import "io"
 //   moov : the movie box (meta-data)
 //   mdat : the media data (chunks and samples)
 //
-// Other boxes can also be present (pdin, moof, mfra, free, ...), but are not decoded.
+// Other top-level boxes (free, uuid, ...) are stored in Other, including the mdat boxes following the first
+// one (as *MdatBox). Top-level boxes are encoded back in their original order.
 type MP4 struct {
-	Ftyp *FtypBox
-	Moov *MoovBox
-	Mdat *MdatBox
+	Ftyp  *FtypBox
+	Moov  *MoovBox
+	Mdat  *MdatBox
+	Other []Box
+	order []string
 }
 
+// Decode a MPEG-4 content from a reader. Errors are returned as a *DecodeError.
+//
+// A mdat box extending to the end of the file (size 0) can be decoded from a reader that cannot seek : its
+// content is then copied until EOF when encoding (see MdatBox.UnknownSize).
+//
+// Decoding stops at the first mdat box, its content being read when encoding : boxes located after it (e.g.
+// other mdat boxes, free space) are neither decoded nor encoded. Use DecodeReadSeeker or DecodeAt to keep them.
 func Decode(r io.Reader) (*MP4, error) {
-	h, err := DecodeHeader(r)
+	br := &boxReader{r: r}
+	h, err := DecodeHeader(br)
 	if err != nil {
-		return nil, err
+		return nil, decodeError("", 0, err)
 	}
 	if h.Type != "ftyp" {
-		return nil, ErrBadFormat
+		return nil, decodeError(h.Type, 0, ErrBadFormat)
 	}
-	ftyp, err := DecodeBox(h, r)
+	ftyp, err := DecodeBox(h, br)
 	if err != nil {
 		return nil, err
 	}
-	h, err = DecodeHeader(r)
+	offset := br.offset
+	h, err = DecodeHeader(br)
+	if err != nil {
+		return nil, decodeError("", offset, err)
+	}
 	if h.Type != "moov" {
-		return nil, ErrBadFormat
+		return nil, decodeError(h.Type, offset, ErrBadFormat)
 	}
-	moov, err := DecodeBox(h, r)
+	moov, err := DecodeBox(h, br)
 	if err != nil {
 		return nil, err
 	}
-	v := &MP4{
-		Ftyp: ftyp.(*FtypBox),
-		Moov: moov.(*MoovBox),
+	v := &MP4{order: []string{"ftyp", "moov"}}
+	var ok bool
+	v.Ftyp, ok = ftyp.(*FtypBox)
+	if !ok {
+		return nil, decodeError("ftyp", 0, ErrBadFormat)
+	}
+	v.Moov, ok = moov.(*MoovBox)
+	if !ok {
+		return nil, decodeError("moov", offset, ErrBadFormat)
 	}
 	for {
-		h, err = DecodeHeader(r)
+		offset = br.offset
+		h, err = DecodeHeader(br)
 		if err != nil {
 			break
 		}
 		if h.Type != "mdat" {
-			DecodeBox(h, r)
+			b, err := DecodeBox(h, br)
+			if err != nil {
+				return nil, err
+			}
+			v.Other = append(v.Other, b)
+			v.order = append(v.order, h.Type)
 		} else {
-			mdat, err := DecodeBox(h, r)
+			mdat, err := DecodeBox(h, br)
 			if err != nil {
 				return nil, err
 			}
-			v.Mdat = mdat.(*MdatBox)
-			v.Mdat.ContentSize = h.Size - BoxHeaderSize
+			v.Mdat, ok = mdat.(*MdatBox)
+			if !ok {
+				return nil, decodeError("mdat", offset, ErrBadFormat)
+			}
+			v.Mdat.Offset = br.offset
+			v.order = append(v.order, "mdat")
+			if h.Size == 0 {
+				v.Mdat.sizeZero = true
+				v.Mdat.ContentSize, err = remainingSize(r)
+				if err == ErrUnknownSize {
+					v.Mdat.unknownSize = true
+				} else if err != nil {
+					return nil, decodeError("mdat", offset, err)
+				}
+			} else {
+				v.Mdat.ContentSize = h.Size - h.HeaderSize
+			}
 			break
 		}
 
@@ -62,19 +104,177 @@ func Decode(r io.Reader) (*MP4, error) {
 	return v, nil
 }
 
+// DecodeReadSeeker decodes a MPEG-4 content from the beginning of a io.ReadSeeker. Errors are returned
+// as a *DecodeError.
+//
+// Unlike Decode, top-level boxes may come in any order (e.g. moov after mdat, as written by most
+// recorders) : the mdat box is skipped by seeking, and its content is read back from rs when encoding.
+// Media may contain several mdat boxes : the first one is Mdat, the next ones are stored in Other.
+//
+// When rs is also a io.ReaderAt (e.g. a *os.File), mdat content is read with ReadAt, see MdatBox.ReaderAt.
+// ErrBadFormat is returned when a mdat box extends beyond the end of rs (truncated media).
+func DecodeReadSeeker(rs io.ReadSeeker) (*MP4, error) {
+	end, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, decodeError("", 0, err)
+	}
+	_, err = rs.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, decodeError("", 0, err)
+	}
+	br := &boxReader{r: rs}
+	v := &MP4{}
+	for {
+		offset := br.offset
+		h, err := DecodeHeader(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, decodeError("", offset, err)
+		}
+		if h.Type == "mdat" {
+			mdat, err := seekMdat(rs, h, br.offset)
+			if err != nil {
+				return nil, decodeError("mdat", offset, err)
+			}
+			if br.offset > end || mdat.ContentSize > uint64(end-br.offset) {
+				return nil, decodeError("mdat", offset, ErrBadFormat)
+			}
+			br.offset, err = rs.Seek(int64(mdat.ContentSize), io.SeekCurrent)
+			if err != nil {
+				return nil, decodeError("mdat", offset, err)
+			}
+			if v.Mdat == nil {
+				v.Mdat = mdat
+			} else {
+				v.Other = append(v.Other, mdat)
+			}
+			v.order = append(v.order, h.Type)
+			continue
+		}
+		b, err := DecodeBox(h, br)
+		if err != nil {
+			return nil, err
+		}
+		switch c := b.(type) {
+		case *FtypBox:
+			if v.Ftyp != nil {
+				return nil, decodeError("ftyp", offset, ErrBadFormat)
+			}
+			v.Ftyp = c
+		case *MoovBox:
+			if v.Moov != nil {
+				return nil, decodeError("moov", offset, ErrBadFormat)
+			}
+			v.Moov = c
+		default:
+			v.Other = append(v.Other, b)
+		}
+		v.order = append(v.order, h.Type)
+	}
+	if v.Ftyp == nil {
+		return nil, decodeError("ftyp", 0, ErrBadFormat)
+	}
+	if v.Moov == nil {
+		return nil, decodeError("moov", 0, ErrBadFormat)
+	}
+	return v, nil
+}
+
+// DecodeAt decodes a MPEG-4 content of the given size from a io.ReaderAt. Errors are returned as a *DecodeError.
+//
+// Only meta-data boxes are read : the mdat content is read from r when needed, and the returned MP4 can be
+// encoded several times, concurrently. r is read through a io.SectionReader (see MdatBox.ReaderAt), use
+// DecodeReadSeeker to keep a *os.File as the reader of the mdat box.
+func DecodeAt(r io.ReaderAt, size int64) (*MP4, error) {
+	return DecodeReadSeeker(io.NewSectionReader(r, 0, size))
+}
+
+// seekMdat returns a mdat box whose content starts at offset in rs, and will be read when encoding.
+func seekMdat(rs io.ReadSeeker, h BoxHeader, offset int64) (*MdatBox, error) {
+	b := &MdatBox{Offset: offset}
+	if h.HeaderSize == LargeBoxHeaderSize {
+		b.setLargeHeader()
+	}
+	if h.Size == 0 {
+		b.sizeZero = true
+		sz, err := remainingSize(rs)
+		if err != nil {
+			return nil, err
+		}
+		b.ContentSize = sz
+	} else {
+		b.ContentSize = h.Size - h.HeaderSize
+	}
+	if ra, ok := rs.(io.ReaderAt); ok {
+		b.ra = ra
+	} else {
+		b.r = &seekReader{rs: rs, offset: offset, n: int64(b.ContentSize)}
+	}
+	return b, nil
+}
+
+// remainingSize returns the number of bytes left in r, for boxes extending to the end of the file.
+// It needs r to be an io.Seeker.
+func remainingSize(r io.Reader) (uint64, error) {
+	s, ok := r.(io.Seeker)
+	if !ok {
+		return 0, ErrUnknownSize
+	}
+	cur, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	end, err := s.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.Seek(cur, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(end - cur), nil
+}
+
 func (m *MP4) Dump() {
 	m.Ftyp.Dump()
 	m.Moov.Dump()
 }
 
-func (m *MP4) Encode(w io.Writer) error {
-	err := m.Ftyp.Encode(w)
-	if err != nil {
-		return err
+// Boxes returns the top-level boxes, in encoding order
+//
+// Boxes are returned in their decoding order. Boxes added to Other are placed after the other boxes, but
+// before mdat when it was the last decoded box.
+func (m *MP4) Boxes() []Box {
+	l := []Box{m.Ftyp, m.Moov}
+	l = append(l, m.Other...)
+	if m.Mdat == nil {
+		return sortBoxes(m.order, l)
 	}
-	err = m.Moov.Encode(w)
-	if err != nil {
-		return err
+	order := []string{}
+	pos := -1
+	for _, typ := range m.order {
+		// Mdat is the first decoded mdat box, the next ones are in Other
+		if typ == "mdat" && pos < 0 {
+			pos = len(order)
+		} else {
+			order = append(order, typ)
+		}
+	}
+	l = sortBoxes(order, l)
+	if pos < 0 || pos == len(order) {
+		pos = len(l)
+	}
+	return append(l[:pos], append([]Box{m.Mdat}, l[pos:]...)...)
+}
+
+func (m *MP4) Encode(w io.Writer) error {
+	for _, b := range m.Boxes() {
+		err := b.Encode(w)
+		if err != nil {
+			return err
+		}
 	}
-	return m.Mdat.Encode(w)
+	return nil
 }
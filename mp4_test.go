@@ -0,0 +1,477 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sync"
+	"testing"
+)
+
+func readFile(t testing.TB, name string) []byte {
+	data, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func encode(t *testing.T, m *MP4) []byte {
+	var buf bytes.Buffer
+	err := m.Encode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func checkSame(t *testing.T, name string, expected, actual []byte) {
+	if bytes.Equal(expected, actual) {
+		return
+	}
+	for i := range expected {
+		if i >= len(actual) || expected[i] != actual[i] {
+			t.Fatalf("%s : encoded media differs at offset %d (size %d, expected %d)", name, i, len(actual), len(expected))
+		}
+	}
+	t.Fatalf("%s : encoded media is too long (size %d, expected %d)", name, len(actual), len(expected))
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, name := range []string{"moov_first.mp4", "moov_last.mp4", "unknown_boxes.mp4", "large_header.mp4"} {
+		data := readFile(t, name)
+		m, err := DecodeReadSeeker(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s : %s", name, err)
+		}
+		checkSame(t, name, data, encode(t, m))
+
+		m, err = DecodeAt(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("%s : %s", name, err)
+		}
+		checkSame(t, name, data, encode(t, m))
+		// DecodeAt media can be encoded several times
+		checkSame(t, name, data, encode(t, m))
+
+		if name == "moov_last.mp4" {
+			continue
+		}
+		m, err = Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s : %s", name, err)
+		}
+		checkSame(t, name, data, encode(t, m))
+	}
+}
+
+// DecodeAt media can be encoded and read concurrently (run with -race)
+func TestEncodeConcurrent(t *testing.T) {
+	for _, name := range []string{"moov_first.mp4", "moov_last.mp4", "large_header.mp4"} {
+		data := readFile(t, name)
+		m, err := DecodeAt(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("%s : %s", name, err)
+		}
+		var wg sync.WaitGroup
+		errs := make(chan error, 8)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var buf bytes.Buffer
+				err := m.Encode(&buf)
+				if err == nil && !bytes.Equal(data, buf.Bytes()) {
+					err = fmt.Errorf("encoded media differs (size %d, expected %d)", buf.Len(), len(data))
+				}
+				if err == nil {
+					_, err = m.ReadSample(m.Moov.Trak[0].Tkhd.TrackId, 1)
+				}
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatalf("%s : %s", name, err)
+			}
+		}
+	}
+}
+
+// headerTimes returns the version, duration and creation time fields of a mvhd, tkhd or mdhd box
+func headerTimes(b Box) (version *byte, duration, creation *uint64) {
+	switch c := b.(type) {
+	case *MvhdBox:
+		return &c.Version, &c.Duration, &c.CreationTime
+	case *TkhdBox:
+		return &c.Version, &c.Duration, &c.CreationTime
+	case *MdhdBox:
+		return &c.Version, &c.Duration, &c.CreationTime
+	}
+	return nil, nil, nil
+}
+
+// mvhd, tkhd and mdhd are encoded as version 1 when a time does not fit in 32 bits
+func TestVersion1Headers(t *testing.T) {
+	data := readFile(t, "moov_first.mp4")
+	m, err := DecodeAt(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		b      Box
+		v0, v1 uint64 // sizes
+	}{
+		{m.Moov.Mvhd, 108, 120},
+		{m.Moov.Trak[0].Tkhd, 92, 104},
+		{m.Moov.Trak[0].Mdia.Mdhd, 32, 44},
+	} {
+		for _, v := range []struct {
+			duration, creation uint64
+			version            byte
+		}{
+			{1000, 1000, 0},
+			{math.MaxUint32, math.MaxUint32, 0},
+			{math.MaxUint32 + 1, 1000, 1},
+			{1000, 1 << 40, 1},
+		} {
+			_, duration, creation := headerTimes(c.b)
+			*duration, *creation = v.duration, v.creation
+			size := c.v0
+			if v.version == 1 {
+				size = c.v1
+			}
+			if c.b.Size() != size {
+				t.Fatalf("%s : size %d, expected %d", c.b.Type(), c.b.Size(), size)
+			}
+			d := reencode(t, c.b)
+			version, duration, creation := headerTimes(d)
+			if *version != v.version || *duration != v.duration || *creation != v.creation {
+				t.Fatalf("%s : decoded version %d, duration %d, creation time %d", c.b.Type(), *version, *duration, *creation)
+			}
+			// a box decoded as version 1 keeps its version
+			*duration, *creation = 1000, 1000
+			if version, _, _ = headerTimes(reencode(t, d)); *version != v.version {
+				t.Fatalf("%s : version %d box encoded as version %d", c.b.Type(), v.version, *version)
+			}
+		}
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	data := readFile(t, "moov_first.mp4")
+	for _, size := range []int{len(data) / 2, len(data) - 1} {
+		_, err := DecodeAt(bytes.NewReader(data), int64(size))
+		de, ok := err.(*DecodeError)
+		if !ok || de.Path != "mdat" || !errors.Is(err, ErrBadFormat) {
+			t.Fatalf("size %d : expected a mdat decode error, got %v", size, err)
+		}
+		_, err = DecodeReadSeeker(bytes.NewReader(data[:size]))
+		if !errors.Is(err, ErrBadFormat) {
+			t.Fatalf("size %d : expected ErrBadFormat, got %v", size, err)
+		}
+	}
+}
+
+func TestRoundTripUnknownBoxes(t *testing.T) {
+	m, err := Decode(bytes.NewReader(readFile(t, "unknown_boxes.mp4")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Other) != 2 || m.Other[0].Type() != "free" || m.Other[1].Type() != "uuid" {
+		t.Fatalf("unexpected top-level boxes %v", m.Other)
+	}
+	if len(m.Moov.Other) != 1 || len(m.Moov.Trak[0].Other) != 1 || len(m.Moov.Trak[0].Mdia.Minf.Stbl.Other) != 1 {
+		t.Fatal("unknown boxes were not kept")
+	}
+}
+
+// customBox is returned by the custom decoders of TestCustomDecoders
+type customBox struct {
+	typ  string
+	data []byte
+}
+
+func (b *customBox) Type() string {
+	return b.typ
+}
+
+func (b *customBox) Size() uint64 {
+	return uint64(BoxHeaderSize + len(b.data))
+}
+
+func (b *customBox) Encode(w io.Writer) error {
+	err := EncodeHeader(b, w)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b.data)
+	return err
+}
+
+func TestCustomDecoders(t *testing.T) {
+	decoder := func(typ string) BoxDecoder {
+		return func(r io.Reader) (Box, error) {
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			return &customBox{typ: typ, data: data}, nil
+		}
+	}
+	for _, typ := range []string{"free", "tref", "sgpd"} {
+		RegisterBoxDecoder(typ, decoder(typ))
+		defer delete(decoders, typ)
+	}
+	var uuid [16]byte
+	copy(uuid[:], "fedcba9876543210")
+	RegisterUUIDDecoder(uuid, decoder("uuid"))
+	defer delete(uuidDecoders, uuid)
+
+	data := readFile(t, "unknown_boxes.mp4")
+	m, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name  string
+		other []Box
+	}{
+		{"free", m.Other},
+		{"uuid", m.Moov.Other},
+		{"tref", m.Moov.Trak[0].Other},
+		{"sgpd", m.Moov.Trak[0].Mdia.Minf.Stbl.Other},
+	} {
+		if len(c.other) == 0 {
+			t.Fatalf("%s : no box in Other", c.name)
+		}
+		b, ok := c.other[0].(*customBox)
+		if !ok || b.Type() != c.name {
+			t.Fatalf("%s : unexpected box %T %s", c.name, c.other[0], c.other[0].Type())
+		}
+	}
+	if b, ok := m.Moov.Other[0].(*customBox); !ok || string(b.data[:16]) != "fedcba9876543210" {
+		t.Fatal("the uuid decoder did not read the extended type")
+	}
+	// uuid boxes with another extended type are still decoded as raw boxes
+	if _, ok := m.Other[1].(*RawBox); !ok || m.Other[1].Type() != "uuid" {
+		t.Fatalf("unexpected box %T %s", m.Other[1], m.Other[1].Type())
+	}
+	checkSame(t, "unknown_boxes.mp4", data, encode(t, m))
+}
+
+func TestDecodeError(t *testing.T) {
+	data := readFile(t, "moov_first.mp4")
+	// the sample count of the stsz boxes (at 564 and 1460) exceeds their number of entries
+	for _, c := range []struct {
+		offset int
+		path   string
+	}{
+		{564, "moov/trak[0]/mdia/minf/stbl/stsz"},
+		{1460, "moov/trak[1]/mdia/minf/stbl/stsz"},
+	} {
+		corrupted := append([]byte{}, data...)
+		binary.BigEndian.PutUint32(corrupted[c.offset+BoxHeaderSize+8:], 1000)
+		for _, decode := range []func() (*MP4, error){
+			func() (*MP4, error) { return Decode(bytes.NewReader(corrupted)) },
+			func() (*MP4, error) { return DecodeAt(bytes.NewReader(corrupted), int64(len(corrupted))) },
+		} {
+			_, err := decode()
+			de, ok := err.(*DecodeError)
+			if !ok {
+				t.Fatalf("%s : expected a DecodeError, got %v", c.path, err)
+			}
+			if de.Path != c.path || de.Offset != int64(c.offset) {
+				t.Fatalf("%s : unexpected error %v", c.path, de)
+			}
+			if !errors.Is(err, ErrBadFormat) {
+				t.Fatalf("%s : %v is not ErrBadFormat", c.path, err)
+			}
+			if expected := fmt.Sprintf("%s @%d : bad format", c.path, c.offset); err.Error() != expected {
+				t.Fatalf("%s : unexpected message %q", c.path, err.Error())
+			}
+		}
+	}
+}
+
+func TestLargeHeader(t *testing.T) {
+	data := readFile(t, "large_header.mp4")
+	m, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []Box{m.Ftyp, m.Moov.Trak[0], m.Moov.Trak[0].Mdia.Hdlr, m.Other[1], m.Mdat} {
+		if headerSize(b) != LargeBoxHeaderSize {
+			t.Errorf("%s : large header was not kept", b.Type())
+		}
+	}
+	if headerSize(m.Moov) != BoxHeaderSize {
+		t.Error("moov : unexpected large header")
+	}
+}
+
+func TestMdatToEOF(t *testing.T) {
+	data := readFile(t, "moov_first.mp4")
+	pos := bytes.LastIndex(data, []byte("mdat")) - 4
+	copy(data[pos:], []byte{0, 0, 0, 0})
+
+	// not seekable : the content size is unknown
+	m, err := Decode(io.MultiReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Mdat.UnknownSize() {
+		t.Fatal("mdat size should be unknown")
+	}
+	checkSame(t, "not seekable", data, encode(t, m))
+
+	m, err = DecodeReadSeeker(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Mdat.UnknownSize() || m.Mdat.ContentSize != uint64(len(data)-pos-BoxHeaderSize) {
+		t.Fatalf("unexpected mdat size %d", m.Mdat.ContentSize)
+	}
+	checkSame(t, "seekable", data, encode(t, m))
+}
+
+// splitMdat splits the mdat box of a media having its moov box first in two mdat boxes, at a chunk offset
+func splitMdat(t *testing.T, data []byte) []byte {
+	m, err := DecodeAt(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stbl := m.Moov.Trak[0].Mdia.Minf.Stbl
+	split := stbl.GetChunkOffset(stbl.ChunkCount()/2 + 1)
+	for _, tr := range m.Moov.Trak {
+		stbl := tr.Mdia.Minf.Stbl
+		offsets := make([]uint64, stbl.ChunkCount())
+		for i := range offsets {
+			offsets[i] = stbl.GetChunkOffset(i + 1)
+			if offsets[i] >= split {
+				offsets[i] += BoxHeaderSize
+			}
+		}
+		stbl.SetChunkOffsets(offsets)
+	}
+	var buf bytes.Buffer
+	for _, b := range []Box{m.Ftyp, m.Moov} {
+		if err = b.Encode(&buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	content := data[m.Mdat.Offset:]
+	first := content[:split-uint64(m.Mdat.Offset)]
+	for _, c := range [][]byte{first, content[len(first):]} {
+		EncodeHeader(&MdatBox{ContentSize: uint64(len(c))}, &buf)
+		buf.Write(c)
+	}
+	return buf.Bytes()
+}
+
+func TestMultipleMdat(t *testing.T) {
+	orig := readFile(t, "moov_first.mp4")
+	data := splitMdat(t, orig)
+	m, err := DecodeAt(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Other) != 1 || m.Other[0].Type() != "mdat" || m.Other[0].(*MdatBox).Offset <= m.Mdat.Offset {
+		t.Fatalf("unexpected top-level boxes %v", m.Other)
+	}
+	checkSame(t, "multiple mdat", data, encode(t, m))
+
+	om, err := DecodeAt(bytes.NewReader(orig), int64(len(orig)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := om.Samples()
+	for {
+		s, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := om.ReadSample(s.Track, int(s.Number))
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := m.ReadSample(s.Track, int(s.Number))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected, actual) {
+			t.Fatalf("track %d : sample %d differs", s.Track, s.Number)
+		}
+	}
+}
+
+func TestReadSampleBadChunk(t *testing.T) {
+	data := readFile(t, "moov_first.mp4")
+	for _, c := range []struct {
+		name            string
+		firstChunk, spc []uint32
+	}{
+		{"first chunk 0", []uint32{0}, []uint32{1}},
+		{"chunk out of range", []uint32{1, 1000000}, []uint32{1, 1}},
+	} {
+		m, err := DecodeAt(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stbl := m.Moov.Trak[0].Mdia.Minf.Stbl
+		stbl.Stsc.FirstChunk, stbl.Stsc.SamplesPerChunk = c.firstChunk, c.spc
+		stbl.Stsc.SampleDescriptionID = make([]uint32, len(c.spc))
+		_, err = m.ReadSample(m.Moov.Trak[0].Tkhd.TrackId, stbl.SampleCount())
+		if err != ErrBadFormat {
+			t.Errorf("%s : expected ErrBadFormat, got %v", c.name, err)
+		}
+	}
+}
+
+func TestReadSampleOversized(t *testing.T) {
+	data := readFile(t, "moov_first.mp4")
+	for _, decode := range []func() (*MP4, error){
+		func() (*MP4, error) { return DecodeAt(bytes.NewReader(data), int64(len(data))) },
+		func() (*MP4, error) { return Decode(bytes.NewReader(data)) },
+	} {
+		m, err := decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		trak := m.Moov.Trak[0]
+		stbl := trak.Mdia.Minf.Stbl
+		n := stbl.SampleCount()
+		sizes := make([]uint32, n)
+		for i := range sizes {
+			sizes[i] = stbl.GetSampleSize(i + 1)
+		}
+		// the size read from the sample table must be checked before allocating data
+		sizes[n-1] = 0xffffffff
+		stbl.SetSampleSizes(sizes)
+		if m.Mdat.ReaderAt() != nil {
+			_, err = m.ReadSample(trak.Tkhd.TrackId, n)
+			if err != ErrBadFormat {
+				t.Fatalf("expected ErrBadFormat, got %v", err)
+			}
+		}
+		r := NewSampleReader(m, trak.Samples())
+		for {
+			_, _, err = r.Next()
+			if err != nil {
+				break
+			}
+		}
+		if err != ErrBadFormat {
+			t.Fatalf("expected ErrBadFormat, got %v", err)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"time"
 )
 
@@ -12,54 +13,95 @@ import (
 //
 // Contained in : Movie Box (‘moov’)
 //
-// Status: version 0 is partially decoded. version 1 is not supported
+// Status: partially decoded (versions 0 and 1)
 //
 // Contains all media information (duration, ...).
 //
 // Duration is measured in "time units", and timescale defines the number of time units per second.
 //
-// Only version 0 is decoded.
+// Creation, modification times and Duration are 64 bits wide in version 1. A box decoded as version 0 is
+// written as version 1 when one of them exceeds 32 bits (e.g. the duration of a long recording).
 type MvhdBox struct {
+	header
 	Version          byte
 	Flags            [3]byte
-	CreationTime     uint32
-	ModificationTime uint32
+	CreationTime     uint64
+	ModificationTime uint64
 	Timescale        uint32
-	Duration         uint32
+	Duration         uint64
 	NextTrackId      uint32
 	Rate             Fixed32
 	Volume           Fixed16
 	notDecoded       []byte
 }
 
+// offset of next track ID in the data following volume (reserved, matrix and pre-defined fields)
+const mvhdNextTrackIdOffset = 70
+
 func DecodeMvhd(r io.Reader) (Box, error) {
 	data, err := ioutil.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
-	return &MvhdBox{
-		Version:          data[0],
-		Flags:            [3]byte{data[1], data[2], data[3]},
-		CreationTime:     binary.BigEndian.Uint32(data[4:8]),
-		ModificationTime: binary.BigEndian.Uint32(data[8:12]),
-		Timescale:        binary.BigEndian.Uint32(data[12:16]),
-		Duration:         binary.BigEndian.Uint32(data[16:20]),
-		Rate:             fixed32(data[20:24]),
-		Volume:           fixed16(data[24:26]),
-		notDecoded:       data[26:],
-	}, nil
+	if len(data) < 4 {
+		return nil, ErrBadFormat
+	}
+	b := &MvhdBox{
+		Version: data[0],
+		Flags:   [3]byte{data[1], data[2], data[3]},
+	}
+	var off int
+	if b.Version == 1 {
+		if len(data) < 38 {
+			return nil, ErrBadFormat
+		}
+		b.CreationTime = binary.BigEndian.Uint64(data[4:12])
+		b.ModificationTime = binary.BigEndian.Uint64(data[12:20])
+		b.Timescale = binary.BigEndian.Uint32(data[20:24])
+		b.Duration = binary.BigEndian.Uint64(data[24:32])
+		off = 32
+	} else {
+		if len(data) < 26 {
+			return nil, ErrBadFormat
+		}
+		b.CreationTime = uint64(binary.BigEndian.Uint32(data[4:8]))
+		b.ModificationTime = uint64(binary.BigEndian.Uint32(data[8:12]))
+		b.Timescale = binary.BigEndian.Uint32(data[12:16])
+		b.Duration = uint64(binary.BigEndian.Uint32(data[16:20]))
+		off = 20
+	}
+	if b.Timescale == 0 {
+		return nil, ErrBadFormat
+	}
+	b.Rate = fixed32(data[off : off+4])
+	b.Volume = fixed16(data[off+4 : off+6])
+	b.notDecoded = data[off+6:]
+	if len(b.notDecoded) >= mvhdNextTrackIdOffset+4 {
+		b.NextTrackId = binary.BigEndian.Uint32(b.notDecoded[mvhdNextTrackIdOffset:])
+	}
+	return b, nil
 }
 
 func (b *MvhdBox) Type() string {
 	return "mvhd"
 }
 
-func (b *MvhdBox) Size() int {
-	return BoxHeaderSize + 26 + len(b.notDecoded)
+func (b *MvhdBox) version() byte {
+	if b.Version == 1 || b.CreationTime > math.MaxUint32 || b.ModificationTime > math.MaxUint32 || b.Duration > math.MaxUint32 {
+		return 1
+	}
+	return 0
+}
+
+func (b *MvhdBox) Size() uint64 {
+	if b.version() == 1 {
+		return b.boxSize(38 + uint64(len(b.notDecoded)))
+	}
+	return b.boxSize(26 + uint64(len(b.notDecoded)))
 }
 
 func (b *MvhdBox) Dump() {
-	fmt.Printf("Movie Header:\n Timescale: %d units/sec\n Duration: %d units (%s)\n Rate: %s\n Volume: %s\n", b.Timescale, b.Duration, time.Duration(b.Duration/b.Timescale)*time.Second, b.Rate, b.Volume)
+	fmt.Printf("Movie Header:\n Timescale: %d units/sec\n Duration: %d units (%s)\n Rate: %s\n Volume: %s\n", b.Timescale, b.Duration, time.Duration(b.Duration/uint64(b.Timescale))*time.Second, b.Rate, b.Volume)
 }
 
 func (b *MvhdBox) Encode(w io.Writer) error {
@@ -68,15 +110,28 @@ func (b *MvhdBox) Encode(w io.Writer) error {
 		return err
 	}
 	buf := makebuf(b)
-	buf[0] = b.Version
+	buf[0] = b.version()
 	buf[1], buf[2], buf[3] = b.Flags[0], b.Flags[1], b.Flags[2]
-	binary.BigEndian.PutUint32(buf[4:], b.CreationTime)
-	binary.BigEndian.PutUint32(buf[8:], b.ModificationTime)
-	binary.BigEndian.PutUint32(buf[12:], b.Timescale)
-	binary.BigEndian.PutUint32(buf[16:], b.Duration)
-	binary.BigEndian.PutUint32(buf[20:], uint32(b.Rate))
-	binary.BigEndian.PutUint16(buf[24:], uint16(b.Volume))
-	copy(buf[26:], b.notDecoded)
+	var off int
+	if buf[0] == 1 {
+		binary.BigEndian.PutUint64(buf[4:], b.CreationTime)
+		binary.BigEndian.PutUint64(buf[12:], b.ModificationTime)
+		binary.BigEndian.PutUint32(buf[20:], b.Timescale)
+		binary.BigEndian.PutUint64(buf[24:], b.Duration)
+		off = 32
+	} else {
+		binary.BigEndian.PutUint32(buf[4:], uint32(b.CreationTime))
+		binary.BigEndian.PutUint32(buf[8:], uint32(b.ModificationTime))
+		binary.BigEndian.PutUint32(buf[12:], b.Timescale)
+		binary.BigEndian.PutUint32(buf[16:], uint32(b.Duration))
+		off = 20
+	}
+	putFixed32(buf[off:], b.Rate)
+	putFixed16(buf[off+4:], b.Volume)
+	copy(buf[off+6:], b.notDecoded)
+	if len(b.notDecoded) >= mvhdNextTrackIdOffset+4 {
+		binary.BigEndian.PutUint32(buf[off+6+mvhdNextTrackIdOffset:], b.NextTrackId)
+	}
 	_, err = w.Write(buf)
 	return err
 }
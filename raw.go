@@ -0,0 +1,46 @@
+package mp4
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// Raw Box (any box type that has no decoder)
+//
+// Status: not decoded
+//
+// The box type and content are kept as is, so that unknown boxes (tref, sgpd, uuid, ...)
+// can be encoded back unchanged. For uuid boxes, the content starts with the 16 bytes extended type.
+type RawBox struct {
+	header
+	BoxType string
+	Content []byte
+}
+
+func DecodeRaw(boxType string, r io.Reader) (Box, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return &RawBox{
+		BoxType: boxType,
+		Content: data,
+	}, nil
+}
+
+func (b *RawBox) Type() string {
+	return b.BoxType
+}
+
+func (b *RawBox) Size() uint64 {
+	return b.boxSize(uint64(len(b.Content)))
+}
+
+func (b *RawBox) Encode(w io.Writer) error {
+	err := EncodeHeader(b, w)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b.Content)
+	return err
+}
@@ -0,0 +1,345 @@
+package mp4
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+var (
+	ErrUnknownTrack  = errors.New("unknown track")
+	ErrUnknownSample = errors.New("unknown sample")
+	ErrNotSeekable   = errors.New("media data is not seekable")
+)
+
+// A Sample, as described by the sample table (stbl) of a track. Times are in media time units (mdhd timescale).
+type Sample struct {
+	Track           uint32 // track ID (tkhd)
+	Number          uint32 // starting at 1
+	Chunk           uint32 // starting at 1
+	Offset          uint64 // absolute offset in the file
+	Size            uint32
+	DecodeTime      uint64
+	CompositionTime int64
+	Duration        uint32
+	DescriptionID   uint32 // sample description (stsd) index, starting at 1
+	Sync            bool   // sync sample (key frame)
+}
+
+// sampleTable walks the sample table of a track in a single pass (stts, ctts, stsc, stsz/stz2, stco/co64, stss)
+type sampleTable struct {
+	track       uint32
+	stbl        *StblBox
+	count       int
+	n           int    // last returned sample
+	chunk       int    // current chunk
+	left        uint32 // samples left in the current chunk
+	offset      uint64 // offset of the next sample in the current chunk
+	sci         int    // stsc entry
+	sti         int    // stts entry
+	stLeft      uint32 // samples left in the stts entry
+	cti         int    // ctts entry
+	ctLeft      uint32 // samples left in the ctts entry
+	ssi         int    // stss entry
+	decodeTime  uint64
+	description uint32
+}
+
+func newSampleTable(t *TrakBox) *sampleTable {
+	s := &sampleTable{}
+	if t.Tkhd != nil {
+		s.track = t.Tkhd.TrackId
+	}
+	if t.Mdia != nil && t.Mdia.Minf != nil {
+		s.stbl = t.Mdia.Minf.Stbl
+	}
+	if s.stbl != nil {
+		s.count = s.stbl.SampleCount()
+	}
+	return s
+}
+
+func (s *sampleTable) next() (Sample, error) {
+	if s.stbl == nil {
+		return Sample{}, ErrBadFormat
+	}
+	if s.n >= s.count {
+		return Sample{}, io.EOF
+	}
+	s.n++
+	stbl := s.stbl
+	// stsc/stco : move to the next chunk when the current one is done
+	for s.left == 0 {
+		s.chunk++
+		if s.chunk > stbl.ChunkCount() || stbl.Stsc == nil {
+			return Sample{}, ErrBadFormat
+		}
+		stsc := stbl.Stsc
+		for s.sci < len(stsc.FirstChunk)-1 && uint32(s.chunk) >= stsc.FirstChunk[s.sci+1] {
+			s.sci++
+		}
+		if s.sci >= len(stsc.FirstChunk) {
+			return Sample{}, ErrBadFormat
+		}
+		s.left = stsc.SamplesPerChunk[s.sci]
+		s.description = stsc.SampleDescriptionID[s.sci]
+		s.offset = stbl.GetChunkOffset(s.chunk)
+	}
+	// stts
+	stts := stbl.Stts
+	for stts != nil && s.stLeft == 0 && s.sti < len(stts.SampleCount) {
+		s.stLeft = stts.SampleCount[s.sti]
+		s.sti++
+	}
+	if s.stLeft == 0 {
+		return Sample{}, ErrBadFormat
+	}
+	smp := Sample{
+		Track:         s.track,
+		Number:        uint32(s.n),
+		Chunk:         uint32(s.chunk),
+		Offset:        s.offset,
+		Size:          stbl.GetSampleSize(s.n),
+		DecodeTime:    s.decodeTime,
+		Duration:      stts.SampleTimeDelta[s.sti-1],
+		DescriptionID: s.description,
+		Sync:          true,
+	}
+	smp.CompositionTime = int64(smp.DecodeTime)
+	s.left--
+	s.stLeft--
+	s.offset += uint64(smp.Size)
+	s.decodeTime += uint64(smp.Duration)
+	// ctts
+	if ctts := stbl.Ctts; ctts != nil {
+		for s.ctLeft == 0 && s.cti < len(ctts.SampleCount) {
+			s.ctLeft = ctts.SampleCount[s.cti]
+			s.cti++
+		}
+		if s.ctLeft > 0 {
+			if ctts.Version == 1 {
+				smp.CompositionTime += int64(int32(ctts.SampleOffset[s.cti-1]))
+			} else {
+				smp.CompositionTime += int64(ctts.SampleOffset[s.cti-1])
+			}
+			s.ctLeft--
+		}
+	}
+	// stss
+	if stss := stbl.Stss; stss != nil {
+		for s.ssi < len(stss.SampleNumber) && stss.SampleNumber[s.ssi] < smp.Number {
+			s.ssi++
+		}
+		smp.Sync = s.ssi < len(stss.SampleNumber) && stss.SampleNumber[s.ssi] == smp.Number
+	}
+	return smp, nil
+}
+
+// SampleIterator iterates over samples, see TrakBox.Samples and MP4.Samples
+type SampleIterator struct {
+	tables []*sampleTable
+	heads  []*Sample
+	err    error
+}
+
+func newSampleIterator(tables []*sampleTable) *SampleIterator {
+	it := &SampleIterator{tables: tables, heads: make([]*Sample, len(tables))}
+	for i := range tables {
+		it.fill(i)
+	}
+	return it
+}
+
+// fill reads the next sample of table i
+func (it *SampleIterator) fill(i int) {
+	s, err := it.tables[i].next()
+	switch {
+	case err == io.EOF:
+		it.heads[i] = nil
+	case err != nil:
+		it.heads[i] = nil
+		if it.err == nil {
+			it.err = err
+		}
+	default:
+		it.heads[i] = &s
+	}
+}
+
+// Next returns the next sample. It returns io.EOF when all samples were read, or ErrBadFormat when the
+// sample tables are inconsistent.
+func (it *SampleIterator) Next() (Sample, error) {
+	if it.err != nil {
+		return Sample{}, it.err
+	}
+	next := -1
+	for i, s := range it.heads {
+		if s != nil && (next < 0 || s.Offset < it.heads[next].Offset) {
+			next = i
+		}
+	}
+	if next < 0 {
+		return Sample{}, io.EOF
+	}
+	s := *it.heads[next]
+	it.fill(next)
+	return s, nil
+}
+
+// Samples returns an iterator over the samples of the track, in decode order
+func (b *TrakBox) Samples() *SampleIterator {
+	return newSampleIterator([]*sampleTable{newSampleTable(b)})
+}
+
+// Samples returns an iterator over the samples of all tracks, in file offset order
+func (m *MP4) Samples() *SampleIterator {
+	tables := []*sampleTable{}
+	for _, t := range m.Moov.Trak {
+		tables = append(tables, newSampleTable(t))
+	}
+	return newSampleIterator(tables)
+}
+
+// Track returns the track with the given ID (tkhd), or nil
+func (m *MP4) Track(id uint32) *TrakBox {
+	for _, t := range m.Moov.Trak {
+		if t.Tkhd != nil && t.Tkhd.TrackId == id {
+			return t
+		}
+	}
+	return nil
+}
+
+// locate returns the offset and size of sample n (starting at 1), using stsc, stco/co64 and stsz/stz2
+func (b *StblBox) locate(n int) (uint64, uint32, error) {
+	if n < 1 || n > b.SampleCount() || b.Stsc == nil {
+		return 0, 0, ErrUnknownSample
+	}
+	stsc := b.Stsc
+	first := 1 // first sample of the stsc entry
+	for i := range stsc.FirstChunk {
+		lastChunk := b.ChunkCount()
+		if i < len(stsc.FirstChunk)-1 {
+			lastChunk = int(stsc.FirstChunk[i+1]) - 1
+		}
+		spc := int(stsc.SamplesPerChunk[i])
+		chunks := lastChunk - int(stsc.FirstChunk[i]) + 1
+		if spc == 0 || chunks <= 0 {
+			continue
+		}
+		if n >= first+chunks*spc {
+			first += chunks * spc
+			continue
+		}
+		chunk := int(stsc.FirstChunk[i]) + (n-first)/spc
+		if chunk < 1 || chunk > b.ChunkCount() {
+			// first_chunk values (stsc) do not match the chunk offset table
+			return 0, 0, ErrBadFormat
+		}
+		offset := b.GetChunkOffset(chunk)
+		for j := n - (n-first)%spc; j < n; j++ {
+			offset += uint64(b.GetSampleSize(j))
+		}
+		return offset, b.GetSampleSize(n), nil
+	}
+	return 0, 0, ErrBadFormat
+}
+
+// ReadSample returns the data of sample n (starting at 1) of the track with the given ID.
+//
+// The media must have been decoded from a io.ReaderAt (see DecodeAt), otherwise ErrNotSeekable is returned.
+func (m *MP4) ReadSample(track uint32, n int) ([]byte, error) {
+	t := m.Track(track)
+	if t == nil || t.Mdia == nil || t.Mdia.Minf == nil || t.Mdia.Minf.Stbl == nil {
+		return nil, ErrUnknownTrack
+	}
+	if m.Mdat == nil || m.Mdat.ra == nil {
+		return nil, ErrNotSeekable
+	}
+	offset, size, err := t.Mdia.Minf.Stbl.locate(n)
+	if err != nil {
+		return nil, err
+	}
+	if !m.inMdat(offset, size) {
+		return nil, ErrBadFormat
+	}
+	data := make([]byte, size)
+	_, err = m.Mdat.ra.ReadAt(data, int64(offset))
+	if err == io.EOF && size > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return data, err
+}
+
+// inMdat returns true when the sample located at offset is in the content of a mdat box of the media, so that
+// sizes read from sample tables are checked before allocating their data
+func (m *MP4) inMdat(offset uint64, size uint32) bool {
+	if m.Mdat != nil && m.Mdat.Contains(offset, uint64(size)) {
+		return true
+	}
+	for _, b := range m.Other {
+		if mdat, ok := b.(*MdatBox); ok && mdat.Contains(offset, uint64(size)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SampleReader reads the samples returned by a SampleIterator, along with their data
+//
+// Data is read from the mdat box. When the media was not decoded from a io.ReaderAt, samples must be in file
+// offset order (see MP4.Samples), otherwise ErrNotSeekable is returned.
+type SampleReader struct {
+	m   *MP4
+	it  *SampleIterator
+	ra  io.ReaderAt
+	r   io.Reader
+	pos uint64
+}
+
+// NewSampleReader returns a reader of the samples returned by it, reading data from m
+func NewSampleReader(m *MP4, it *SampleIterator) *SampleReader {
+	r := &SampleReader{m: m, it: it}
+	if m.Mdat != nil {
+		r.ra = m.Mdat.ra
+		r.r = m.Mdat.Reader()
+		r.pos = uint64(m.Mdat.Offset)
+	}
+	return r
+}
+
+// Next returns the next sample and its data. It returns io.EOF when all samples were read.
+func (r *SampleReader) Next() (Sample, []byte, error) {
+	s, err := r.it.Next()
+	if err != nil {
+		return s, nil, err
+	}
+	if r.ra == nil && (r.r == nil || s.Offset < r.pos) {
+		return s, nil, ErrNotSeekable
+	}
+	if !r.m.inMdat(s.Offset, s.Size) {
+		return s, nil, ErrBadFormat
+	}
+	var data []byte
+	if r.ra != nil {
+		data = make([]byte, s.Size)
+		_, err = r.ra.ReadAt(data, int64(s.Offset))
+		if err == io.EOF && s.Size > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+	} else {
+		_, err = io.CopyN(ioutil.Discard, r.r, int64(s.Offset-r.pos))
+		if err == nil {
+			// the size of a mdat box extending to the end of the file may be unknown : data is read as it comes
+			data, err = ioutil.ReadAll(io.LimitReader(r.r, int64(s.Size)))
+		}
+		if err == nil && len(data) != int(s.Size) {
+			err = io.ErrUnexpectedEOF
+		}
+		r.pos = s.Offset + uint64(s.Size)
+	}
+	if err != nil {
+		return s, nil, err
+	}
+	return s, data, nil
+}
@@ -0,0 +1,168 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+	"time"
+)
+
+// A SeekIndex maps presentation times to sync samples and file offsets, for all tracks of a media.
+//
+// It is built once from the sample tables (see NewSeekIndex), and can be serialized (MarshalBinary)
+// to be cached.
+type SeekIndex struct {
+	Tracks []TrackSeekIndex
+}
+
+// TrackSeekIndex lists the seek points of a track, ordered by time
+type TrackSeekIndex struct {
+	TrackID uint32
+	Points  []SeekPoint
+}
+
+// A SeekPoint is a sync sample (for tracks without stss, the first sample of each chunk)
+//
+// Time is the presentation time of the sample (using the edit list), Offset its absolute offset in the file
+// and ChunkOffset the offset of the chunk containing it.
+type SeekPoint struct {
+	Time        time.Duration
+	Sample      uint32
+	Offset      uint64
+	ChunkOffset uint64
+}
+
+// NewSeekIndex builds the seek index of a media
+func NewSeekIndex(m *MP4) (*SeekIndex, error) {
+	if m.Moov.Mvhd == nil {
+		return nil, ErrBadFormat
+	}
+	x := &SeekIndex{}
+	for _, t := range m.Moov.Trak {
+		if t.Tkhd == nil || t.Mdia == nil || t.Mdia.Mdhd == nil || t.Mdia.Minf == nil || t.Mdia.Minf.Stbl == nil {
+			return nil, ErrBadFormat
+		}
+		tx := TrackSeekIndex{TrackID: t.Tkhd.TrackId, Points: []SeekPoint{}}
+		allSync := t.Mdia.Minf.Stbl.Stss == nil
+		var chunk uint32
+		var chunkOffset uint64
+		it := t.Samples()
+		for {
+			s, err := it.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			newChunk := s.Chunk != chunk
+			if newChunk {
+				chunk, chunkOffset = s.Chunk, s.Offset
+			}
+			if !s.Sync || (allSync && !newChunk) {
+				continue
+			}
+			p := SeekPoint{Sample: s.Number, Offset: s.Offset, ChunkOffset: chunkOffset}
+			// samples that are not presented (before or after the edits) keep the previous time
+			if pt, ok := t.PresentationTime(s.CompositionTime, m.Moov.Mvhd.Timescale); ok {
+				p.Time = pt
+			}
+			if n := len(tx.Points); n > 0 && p.Time < tx.Points[n-1].Time {
+				p.Time = tx.Points[n-1].Time
+			}
+			tx.Points = append(tx.Points, p)
+		}
+		x.Tracks = append(x.Tracks, tx)
+	}
+	return x, nil
+}
+
+// Keyframe returns the last seek point at or before t of the track with the given ID.
+// ok is false if the track is unknown or has no seek point before t.
+func (x *SeekIndex) Keyframe(track uint32, t time.Duration) (p SeekPoint, ok bool) {
+	for _, tx := range x.Tracks {
+		if tx.TrackID == track {
+			return tx.keyframe(t)
+		}
+	}
+	return SeekPoint{}, false
+}
+
+func (tx *TrackSeekIndex) keyframe(t time.Duration) (SeekPoint, bool) {
+	i := sort.Search(len(tx.Points), func(i int) bool { return tx.Points[i].Time > t })
+	if i == 0 {
+		return SeekPoint{}, false
+	}
+	return tx.Points[i-1], true
+}
+
+// Offset returns the offset of the earliest chunk needed to play all tracks from t (the chunks containing
+// the seek points returned by Keyframe). ok is false if no track has a seek point before t.
+func (x *SeekIndex) Offset(t time.Duration) (offset uint64, ok bool) {
+	for _, tx := range x.Tracks {
+		p, found := tx.keyframe(t)
+		if found && (!ok || p.ChunkOffset < offset) {
+			offset, ok = p.ChunkOffset, true
+		}
+	}
+	return offset, ok
+}
+
+const seekPointSize = 28
+
+// MarshalBinary encodes the index (implements encoding.BinaryMarshaler)
+func (x *SeekIndex) MarshalBinary() ([]byte, error) {
+	sz := 4
+	for _, tx := range x.Tracks {
+		sz += 8 + len(tx.Points)*seekPointSize
+	}
+	buf := make([]byte, sz)
+	binary.BigEndian.PutUint32(buf, uint32(len(x.Tracks)))
+	off := 4
+	for _, tx := range x.Tracks {
+		binary.BigEndian.PutUint32(buf[off:], tx.TrackID)
+		binary.BigEndian.PutUint32(buf[off+4:], uint32(len(tx.Points)))
+		off += 8
+		for _, p := range tx.Points {
+			binary.BigEndian.PutUint64(buf[off:], uint64(p.Time))
+			binary.BigEndian.PutUint32(buf[off+8:], p.Sample)
+			binary.BigEndian.PutUint64(buf[off+12:], p.Offset)
+			binary.BigEndian.PutUint64(buf[off+20:], p.ChunkOffset)
+			off += seekPointSize
+		}
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes an index encoded by MarshalBinary (implements encoding.BinaryUnmarshaler)
+func (x *SeekIndex) UnmarshalBinary(data []byte) error {
+	if len(data) < 4 {
+		return ErrBadFormat
+	}
+	count := binary.BigEndian.Uint32(data)
+	off := 4
+	tracks := []TrackSeekIndex{}
+	for i := uint32(0); i < count; i++ {
+		if !hasEntries(data, off, 1, 8) {
+			return ErrBadFormat
+		}
+		tx := TrackSeekIndex{TrackID: binary.BigEndian.Uint32(data[off:]), Points: []SeekPoint{}}
+		n := binary.BigEndian.Uint32(data[off+4:])
+		off += 8
+		if !hasEntries(data, off, n, seekPointSize) {
+			return ErrBadFormat
+		}
+		for j := uint32(0); j < n; j++ {
+			tx.Points = append(tx.Points, SeekPoint{
+				Time:        time.Duration(binary.BigEndian.Uint64(data[off:])),
+				Sample:      binary.BigEndian.Uint32(data[off+8:]),
+				Offset:      binary.BigEndian.Uint64(data[off+12:]),
+				ChunkOffset: binary.BigEndian.Uint64(data[off+20:]),
+			})
+			off += seekPointSize
+		}
+		tracks = append(tracks, tx)
+	}
+	x.Tracks = tracks
+	return nil
+}
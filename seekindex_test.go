@@ -0,0 +1,119 @@
+package mp4
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// moov_first.mp4 has a video track (ID 1, key frames every second, at samples 1, 26, 51 and 76) and an audio
+// track (ID 2, without stss, chunks of 5 samples lasting 106.67ms)
+func TestSeekIndex(t *testing.T) {
+	data := readFile(t, "moov_first.mp4")
+	m, err := DecodeAt(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err := NewSeekIndex(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms := time.Millisecond
+	for _, c := range []struct {
+		track  uint32
+		t      time.Duration
+		ok     bool
+		sample uint32
+		time   time.Duration
+	}{
+		{1, 0, true, 1, 0},
+		{1, 999 * ms, true, 1, 0},
+		{1, 1500 * ms, true, 26, time.Second},
+		{1, 10 * time.Second, true, 76, 3 * time.Second},
+		{1, -ms, false, 0, 0},
+		{2, 100 * ms, true, 1, 0},
+		{2, 1500 * ms, true, 71, 1493333333},
+		{3, time.Second, false, 0, 0},
+	} {
+		p, ok := x.Keyframe(c.track, c.t)
+		if ok != c.ok || p.Sample != c.sample || p.Time != c.time {
+			t.Errorf("track %d at %s : unexpected seek point %+v (%v)", c.track, c.t, p, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		offset, _, err := m.Track(c.track).Mdia.Minf.Stbl.locate(int(p.Sample))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// seek points are the first samples of their chunk
+		if p.Offset != offset || p.ChunkOffset != offset {
+			t.Errorf("track %d at %s : unexpected offsets %d %d, expected %d", c.track, c.t, p.Offset, p.ChunkOffset, offset)
+		}
+	}
+
+	for _, c := range []struct {
+		t      time.Duration
+		ok     bool
+		offset uint64
+	}{
+		{0, true, 2006},
+		// video sample 26 is located before audio sample 71
+		{1500 * ms, true, 5894},
+		// video sample 51 is located after audio sample 96, the last seek point of the audio track
+		{2500 * ms, true, 9800},
+		{-ms, false, 0},
+	} {
+		offset, ok := x.Offset(c.t)
+		if ok != c.ok || offset != c.offset {
+			t.Errorf("at %s : offset %d (%v), expected %d", c.t, offset, ok, c.offset)
+		}
+	}
+
+	// samples that are not presented keep the time of the previous seek point
+	m.Moov.Trak[0].Edts.Elst.MediaTime[0] = 12800
+	x, err = NewSeekIndex(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	times := []time.Duration{}
+	for _, p := range x.Tracks[0].Points {
+		times = append(times, p.Time)
+	}
+	if !reflect.DeepEqual(times, []time.Duration{0, 0, time.Second, 2 * time.Second}) {
+		t.Errorf("unexpected times %v", times)
+	}
+}
+
+func TestSeekIndexBinary(t *testing.T) {
+	data := readFile(t, "moov_first.mp4")
+	m, err := DecodeAt(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err := NewSeekIndex(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := x.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 4+2*8+(4+20)*seekPointSize {
+		t.Fatalf("unexpected size %d", len(b))
+	}
+	y := &SeekIndex{}
+	if err = y.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(x, y) {
+		t.Fatalf("decoded index differs : %+v", y)
+	}
+	for n := 0; n < len(b); n++ {
+		if err = y.UnmarshalBinary(b[:n]); err != ErrBadFormat {
+			t.Fatalf("%d bytes : expected ErrBadFormat, got %v", n, err)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 //
 // Status: decoded
 type SmhdBox struct {
+	header
 	Version byte
 	Flags   [3]byte
 	Balance uint16 // should be int16
@@ -22,6 +23,9 @@ func DecodeSmhd(r io.Reader) (Box, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(data) < 6 {
+		return nil, ErrBadFormat
+	}
 	return &SmhdBox{
 		Version: data[0],
 		Flags:   [3]byte{data[1], data[2], data[3]},
@@ -33,8 +37,8 @@ func (b *SmhdBox) Type() string {
 	return "smhd"
 }
 
-func (b *SmhdBox) Size() int {
-	return BoxHeaderSize + 8
+func (b *SmhdBox) Size() uint64 {
+	return b.boxSize(8)
 }
 
 func (b *SmhdBox) Encode(w io.Writer) error {
@@ -1,22 +1,36 @@
 package mp4
 
-import "io"
+import (
+	"io"
+	"math"
+)
 
 // Sample Table Box (stbl - mandatory)
 //
 // Contained in : Media Information Box (minf)
 //
-// Status: partially decoded (anything other than stsd, stts, stsc, stss, stsz, stco, ctts is ignored)
+// Status: partially decoded (anything other than stsd, stts, stsc, stss, stsz, stz2, stco, co64, ctts is stored in Other)
 //
 // The table contains all information relevant to data samples (times, chunks, sizes, ...)
+//
+// Chunk offsets are stored either in stco (32 bits) or in co64 (64 bits). Use ChunkCount, GetChunkOffset
+// and SetChunkOffsets to access them regardless of the box being used.
+//
+// Sample sizes are stored either in stsz or in stz2 (compact sizes). Use SampleCount, GetSampleSize
+// and SetSampleSizes to access them regardless of the box being used.
 type StblBox struct {
-	Stsd *StsdBox
-	Stts *SttsBox
-	Stss *StssBox
-	Stsc *StscBox
-	Stsz *StszBox
-	Stco *StcoBox
-	Ctts *CttsBox
+	header
+	Stsd  *StsdBox
+	Stts  *SttsBox
+	Stss  *StssBox
+	Stsc  *StscBox
+	Stsz  *StszBox
+	Stz2  *Stz2Box
+	Stco  *StcoBox
+	Co64  *Co64Box
+	Ctts  *CttsBox
+	Other []Box
+	order []string
 }
 
 func DecodeStbl(r io.Reader) (Box, error) {
@@ -24,23 +38,29 @@ func DecodeStbl(r io.Reader) (Box, error) {
 	if err != nil {
 		return nil, err
 	}
-	s := &StblBox{}
+	s := &StblBox{order: boxTypes(l)}
 	for _, b := range l {
-		switch b.Type() {
-		case "stsd":
-			s.Stsd = b.(*StsdBox)
-		case "stts":
-			s.Stts = b.(*SttsBox)
-		case "stsc":
-			s.Stsc = b.(*StscBox)
-		case "stss":
-			s.Stss = b.(*StssBox)
-		case "stsz":
-			s.Stsz = b.(*StszBox)
-		case "stco":
-			s.Stco = b.(*StcoBox)
-		case "ctts":
-			s.Ctts = b.(*CttsBox)
+		switch c := b.(type) {
+		case *StsdBox:
+			s.Stsd = c
+		case *SttsBox:
+			s.Stts = c
+		case *StscBox:
+			s.Stsc = c
+		case *StssBox:
+			s.Stss = c
+		case *StszBox:
+			s.Stsz = c
+		case *Stz2Box:
+			s.Stz2 = c
+		case *StcoBox:
+			s.Stco = c
+		case *Co64Box:
+			s.Co64 = c
+		case *CttsBox:
+			s.Ctts = c
+		default:
+			s.Other = append(s.Other, b)
 		}
 	}
 	return s, nil
@@ -50,27 +70,41 @@ func (b *StblBox) Type() string {
 	return "stbl"
 }
 
-func (b *StblBox) Size() int {
-	sz := b.Stsd.Size()
+// children returns the child boxes, in the default encoding order
+func (b *StblBox) children() []Box {
+	l := []Box{}
+	if b.Stsd != nil {
+		l = append(l, b.Stsd)
+	}
 	if b.Stts != nil {
-		sz += b.Stts.Size()
+		l = append(l, b.Stts)
 	}
 	if b.Stss != nil {
-		sz += b.Stss.Size()
+		l = append(l, b.Stss)
 	}
 	if b.Stsc != nil {
-		sz += b.Stsc.Size()
+		l = append(l, b.Stsc)
 	}
 	if b.Stsz != nil {
-		sz += b.Stsz.Size()
+		l = append(l, b.Stsz)
+	}
+	if b.Stz2 != nil {
+		l = append(l, b.Stz2)
 	}
 	if b.Stco != nil {
-		sz += b.Stco.Size()
+		l = append(l, b.Stco)
+	}
+	if b.Co64 != nil {
+		l = append(l, b.Co64)
 	}
 	if b.Ctts != nil {
-		sz += b.Ctts.Size()
+		l = append(l, b.Ctts)
 	}
-	return sz + BoxHeaderSize
+	return append(l, b.Other...)
+}
+
+func (b *StblBox) Size() uint64 {
+	return b.boxSize(childrenSize(b.children()))
 }
 
 func (b *StblBox) Dump() {
@@ -83,47 +117,122 @@ func (b *StblBox) Dump() {
 	if b.Stsz != nil {
 		b.Stsz.Dump()
 	}
+	if b.Stz2 != nil {
+		b.Stz2.Dump()
+	}
 	if b.Stss != nil {
 		b.Stss.Dump()
 	}
 	if b.Stco != nil {
 		b.Stco.Dump()
 	}
+	if b.Co64 != nil {
+		b.Co64.Dump()
+	}
 }
 
-func (b *StblBox) Encode(w io.Writer) error {
-	err := EncodeHeader(b, w)
-	if err != nil {
-		return err
+// SampleCount returns the number of samples in the sample size table (stsz or stz2)
+func (b *StblBox) SampleCount() int {
+	if b.Stz2 != nil {
+		return len(b.Stz2.SampleSize)
 	}
-	err = b.Stsd.Encode(w)
-	if err != nil {
-		return err
+	if b.Stsz != nil {
+		if len(b.Stsz.SampleSize) == 0 {
+			return int(b.Stsz.SampleNumber)
+		}
+		return len(b.Stsz.SampleSize)
 	}
-	err = b.Stts.Encode(w)
-	if err != nil {
-		return err
+	return 0
+}
+
+// GetSampleSize returns the size of sample i (starting at 1), read from stsz or stz2
+func (b *StblBox) GetSampleSize(i int) uint32 {
+	if b.Stz2 != nil {
+		return b.Stz2.GetSampleSize(i)
 	}
-	if b.Stss != nil {
-		err = b.Stss.Encode(w)
-		if err != nil {
-			return err
+	return b.Stsz.GetSampleSize(i)
+}
+
+// SetSampleSizes replaces the sample size table. The stz2 box is kept if all sizes fit in its field size,
+// otherwise a stsz box is used (with a uniform size if the original stsz box had one and it still applies).
+func (b *StblBox) SetSampleSizes(sizes []uint32) {
+	if b.Stz2 != nil && b.Stz2.fits(sizes) {
+		b.Stz2.SampleSize = sizes
+		return
+	}
+	if b.Stsz == nil {
+		b.Stsz = &StszBox{}
+	}
+	b.Stz2 = nil
+	b.Stsz.SampleNumber = uint32(len(sizes))
+	if b.Stsz.SampleUniformSize != 0 {
+		uniform := true
+		for _, sz := range sizes {
+			if sz != b.Stsz.SampleUniformSize {
+				uniform = false
+				break
+			}
+		}
+		if uniform {
+			b.Stsz.SampleSize = []uint32{}
+			return
 		}
+		b.Stsz.SampleUniformSize = 0
 	}
-	err = b.Stsc.Encode(w)
-	if err != nil {
-		return err
+	b.Stsz.SampleSize = sizes
+}
+
+// ChunkCount returns the number of chunks in the chunk offset table (stco or co64)
+func (b *StblBox) ChunkCount() int {
+	if b.Co64 != nil {
+		return len(b.Co64.ChunkOffset)
 	}
-	err = b.Stsz.Encode(w)
-	if err != nil {
-		return err
+	if b.Stco != nil {
+		return len(b.Stco.ChunkOffset)
+	}
+	return 0
+}
+
+// GetChunkOffset returns the offset of chunk i (starting at 1), read from stco or co64
+func (b *StblBox) GetChunkOffset(i int) uint64 {
+	if b.Co64 != nil {
+		return b.Co64.ChunkOffset[i-1]
+	}
+	return uint64(b.Stco.ChunkOffset[i-1])
+}
+
+// SetChunkOffsets replaces the chunk offset table. A stco box is used when all offsets fit in 32 bits,
+// a co64 box otherwise.
+func (b *StblBox) SetChunkOffsets(offsets []uint64) {
+	large := false
+	for _, o := range offsets {
+		if o > math.MaxUint32 {
+			large = true
+			break
+		}
+	}
+	if large {
+		if b.Co64 == nil {
+			b.Co64 = &Co64Box{}
+		}
+		b.Co64.ChunkOffset = offsets
+		b.Stco = nil
+		return
+	}
+	if b.Stco == nil {
+		b.Stco = &StcoBox{}
+	}
+	b.Stco.ChunkOffset = make([]uint32, len(offsets))
+	for i, o := range offsets {
+		b.Stco.ChunkOffset[i] = uint32(o)
 	}
-	err = b.Stco.Encode(w)
+	b.Co64 = nil
+}
+
+func (b *StblBox) Encode(w io.Writer) error {
+	err := EncodeHeader(b, w)
 	if err != nil {
 		return err
 	}
-	if b.Ctts != nil {
-		return b.Ctts.Encode(w)
-	}
-	return nil
+	return encodeOrdered(w, b.order, b.children())
 }
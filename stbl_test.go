@@ -0,0 +1,91 @@
+package mp4
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+// reencode encodes a box and decodes it back, checking its size
+func reencode(t *testing.T, b Box) Box {
+	var buf bytes.Buffer
+	err := b.Encode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uint64(buf.Len()) != b.Size() {
+		t.Fatalf("%s : encoded size %d differs from box size %d", b.Type(), buf.Len(), b.Size())
+	}
+	h, err := DecodeHeader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := DecodeBox(h, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestSetChunkOffsets(t *testing.T) {
+	stbl := &StblBox{Stco: &StcoBox{}}
+	for _, c := range []struct {
+		offsets []uint64
+		co64    bool
+	}{
+		{[]uint64{48, 1000, math.MaxUint32}, false},
+		// promoted to co64
+		{[]uint64{48, 1000, math.MaxUint32 + 1}, true},
+		{[]uint64{48, 1 << 40}, true},
+		// back to stco
+		{[]uint64{48, 1000}, false},
+	} {
+		stbl.SetChunkOffsets(c.offsets)
+		for _, b := range []*StblBox{stbl, reencode(t, stbl).(*StblBox)} {
+			if (b.Co64 != nil) != c.co64 || (b.Stco != nil) == c.co64 {
+				t.Fatalf("%v : unexpected boxes stco %v co64 %v", c.offsets, b.Stco, b.Co64)
+			}
+			if b.ChunkCount() != len(c.offsets) {
+				t.Fatalf("%v : %d chunks", c.offsets, b.ChunkCount())
+			}
+			for i, o := range c.offsets {
+				if b.GetChunkOffset(i+1) != o {
+					t.Fatalf("%v : chunk %d at offset %d", c.offsets, i+1, b.GetChunkOffset(i+1))
+				}
+			}
+		}
+	}
+}
+
+func TestStz2(t *testing.T) {
+	stbl := &StblBox{Stz2: &Stz2Box{FieldSize: 4}}
+	// 4 bits sizes are packed 2 per byte, the last byte is padded
+	sizes := []uint32{1, 15, 0, 7, 9}
+	stbl.SetSampleSizes(sizes)
+	var buf bytes.Buffer
+	if err := stbl.Stz2.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if packed := buf.Bytes()[BoxHeaderSize+12:]; !bytes.Equal(packed, []byte{0x1f, 0x07, 0x90}) {
+		t.Fatalf("unexpected packed sizes %x", packed)
+	}
+	for _, b := range []*StblBox{stbl, reencode(t, stbl).(*StblBox)} {
+		if b.Stz2 == nil || b.Stsz != nil || b.Stz2.FieldSize != 4 {
+			t.Fatalf("unexpected boxes stsz %v stz2 %v", b.Stsz, b.Stz2)
+		}
+		if b.SampleCount() != len(sizes) {
+			t.Fatalf("%d samples", b.SampleCount())
+		}
+		for i, sz := range sizes {
+			if b.GetSampleSize(i+1) != sz {
+				t.Fatalf("sample %d has size %d, expected %d", i+1, b.GetSampleSize(i+1), sz)
+			}
+		}
+	}
+	// sizes that do not fit in 4 bits are stored in stsz
+	sizes = []uint32{1, 16}
+	stbl.SetSampleSizes(sizes)
+	if stbl.Stz2 != nil || stbl.Stsz == nil || stbl.SampleCount() != 2 || stbl.GetSampleSize(2) != 16 {
+		t.Fatalf("unexpected boxes stsz %v stz2 %v", stbl.Stsz, stbl.Stz2)
+	}
+}
@@ -13,11 +13,12 @@ import (
 //
 // Status: decoded
 //
-// This is the 32bits version of the box, the 64bits version (co64) is not decoded.
+// This is the 32bits version of the box, the 64bits version is co64.
 //
 // The table contains the offsets (starting at the beginning of the file) for each chunk of data for the current track.
 // A chunk contains samples, the table defining the allocation of samples to each chunk is stsc.
 type StcoBox struct {
+	header
 	Version     byte
 	Flags       [3]byte
 	ChunkOffset []uint32
@@ -28,12 +29,18 @@ func DecodeStco(r io.Reader) (Box, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(data) < 8 {
+		return nil, ErrBadFormat
+	}
 	b := &StcoBox{
 		Version:     data[0],
 		Flags:       [3]byte{data[1], data[2], data[3]},
 		ChunkOffset: []uint32{},
 	}
 	ec := binary.BigEndian.Uint32(data[4:8])
+	if !hasEntries(data, 8, ec, 4) {
+		return nil, ErrBadFormat
+	}
 	for i := 0; i < int(ec); i++ {
 		chunk := binary.BigEndian.Uint32(data[(8 + 4*i):(12 + 4*i)])
 		b.ChunkOffset = append(b.ChunkOffset, chunk)
@@ -45,8 +52,8 @@ func (b *StcoBox) Type() string {
 	return "stco"
 }
 
-func (b *StcoBox) Size() int {
-	return BoxHeaderSize + 8 + len(b.ChunkOffset)*4
+func (b *StcoBox) Size() uint64 {
+	return b.boxSize(8 + uint64(len(b.ChunkOffset))*4)
 }
 
 func (b *StcoBox) Dump() {
@@ -20,6 +20,7 @@ import (
 //   * samples per chunk : number of samples in the chunk
 //   * description id : description (see the sample description box - stsd)
 type StscBox struct {
+	header
 	Version             byte
 	Flags               [3]byte
 	FirstChunk          []uint32
@@ -32,6 +33,9 @@ func DecodeStsc(r io.Reader) (Box, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(data) < 8 {
+		return nil, ErrBadFormat
+	}
 
 	b := &StscBox{
 		Version:             data[0],
@@ -41,6 +45,9 @@ func DecodeStsc(r io.Reader) (Box, error) {
 		SampleDescriptionID: []uint32{},
 	}
 	ec := binary.BigEndian.Uint32(data[4:8])
+	if !hasEntries(data, 8, ec, 12) {
+		return nil, ErrBadFormat
+	}
 	for i := 0; i < int(ec); i++ {
 		fc := binary.BigEndian.Uint32(data[(8 + 12*i):(12 + 12*i)])
 		spc := binary.BigEndian.Uint32(data[(12 + 12*i):(16 + 12*i)])
@@ -56,8 +63,8 @@ func (b *StscBox) Type() string {
 	return "stsc"
 }
 
-func (b *StscBox) Size() int {
-	return BoxHeaderSize + 8 + len(b.FirstChunk)*12
+func (b *StscBox) Size() uint64 {
+	return b.boxSize(8 + uint64(len(b.FirstChunk))*12)
 }
 
 func (b *StscBox) Dump() {
@@ -1,6 +1,7 @@
 package mp4
 
 import (
+	"encoding/binary"
 	"io"
 	"io/ioutil"
 )
@@ -13,6 +14,7 @@ import (
 //
 // This box contains information that describes how the data can be decoded.
 type StsdBox struct {
+	header
 	Version    byte
 	Flags      [3]byte
 	notDecoded []byte
@@ -23,6 +25,9 @@ func DecodeStsd(r io.Reader) (Box, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(data) < 4 {
+		return nil, ErrBadFormat
+	}
 	return &StsdBox{
 		Version:    data[0],
 		Flags:      [3]byte{data[1], data[2], data[3]},
@@ -34,8 +39,8 @@ func (b *StsdBox) Type() string {
 	return "stsd"
 }
 
-func (b *StsdBox) Size() int {
-	return BoxHeaderSize + 4 + len(b.notDecoded)
+func (b *StsdBox) Size() uint64 {
+	return b.boxSize(4 + uint64(len(b.notDecoded)))
 }
 
 func (b *StsdBox) Encode(w io.Writer) error {
@@ -50,3 +55,35 @@ func (b *StsdBox) Encode(w io.Writer) error {
 	_, err = w.Write(buf)
 	return err
 }
+
+// Entries returns the sample entries (each entry is a box, including its header)
+func (b *StsdBox) Entries() ([][]byte, error) {
+	if len(b.notDecoded) < 4 {
+		return nil, ErrBadFormat
+	}
+	count := binary.BigEndian.Uint32(b.notDecoded[0:4])
+	data := b.notDecoded[4:]
+	entries := [][]byte{}
+	for i := uint32(0); i < count; i++ {
+		if len(data) < BoxHeaderSize {
+			return nil, ErrBadFormat
+		}
+		sz := binary.BigEndian.Uint32(data[0:4])
+		if sz < BoxHeaderSize || uint64(sz) > uint64(len(data)) {
+			return nil, ErrBadFormat
+		}
+		entries = append(entries, data[:sz])
+		data = data[sz:]
+	}
+	return entries, nil
+}
+
+// SetEntries replaces the sample entries
+func (b *StsdBox) SetEntries(entries [][]byte) {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, uint32(len(entries)))
+	for _, e := range entries {
+		data = append(data, e...)
+	}
+	b.notDecoded = data
+}
@@ -15,6 +15,7 @@ import (
 //
 // This lists all sync samples (key frames for video tracks) in the data. If absent, all samples are sync samples.
 type StssBox struct {
+	header
 	Version      byte
 	Flags        [3]byte
 	SampleNumber []uint32
@@ -25,12 +26,18 @@ func DecodeStss(r io.Reader) (Box, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(data) < 8 {
+		return nil, ErrBadFormat
+	}
 	b := &StssBox{
 		Version:      data[0],
 		Flags:        [3]byte{data[1], data[2], data[3]},
 		SampleNumber: []uint32{},
 	}
 	ec := binary.BigEndian.Uint32(data[4:8])
+	if !hasEntries(data, 8, ec, 4) {
+		return nil, ErrBadFormat
+	}
 	for i := 0; i < int(ec); i++ {
 		sample := binary.BigEndian.Uint32(data[(8 + 4*i):(12 + 4*i)])
 		b.SampleNumber = append(b.SampleNumber, sample)
@@ -42,8 +49,8 @@ func (b *StssBox) Type() string {
 	return "stss"
 }
 
-func (b *StssBox) Size() int {
-	return BoxHeaderSize + 8 + len(b.SampleNumber)*4
+func (b *StssBox) Size() uint64 {
+	return b.boxSize(8 + uint64(len(b.SampleNumber))*4)
 }
 
 func (b *StssBox) Dump() {
@@ -13,11 +13,12 @@ import (
 //
 // Status : decoded
 //
-// For each track, either stsz of the more compact stz2 must be present. stz2 variant is not supported.
+// For each track, either stsz of the more compact stz2 must be present (see Stz2Box).
 //
 // This table lists the size of each sample. If all samples have the same size, it can be defined in the
 // SampleUniformSize attribute.
 type StszBox struct {
+	header
 	Version           byte
 	Flags             [3]byte
 	SampleUniformSize uint32
@@ -30,6 +31,9 @@ func DecodeStsz(r io.Reader) (Box, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(data) < 12 {
+		return nil, ErrBadFormat
+	}
 	b := &StszBox{
 		Version:           data[0],
 		Flags:             [3]byte{data[1], data[2], data[3]},
@@ -38,6 +42,9 @@ func DecodeStsz(r io.Reader) (Box, error) {
 		SampleSize:        []uint32{},
 	}
 	if len(data) > 12 {
+		if !hasEntries(data, 12, b.SampleNumber, 4) {
+			return nil, ErrBadFormat
+		}
 		for i := 0; i < int(b.SampleNumber); i++ {
 			sz := binary.BigEndian.Uint32(data[(12 + 4*i):(16 + 4*i)])
 			b.SampleSize = append(b.SampleSize, sz)
@@ -50,8 +57,8 @@ func (b *StszBox) Type() string {
 	return "stsz"
 }
 
-func (b *StszBox) Size() int {
-	return BoxHeaderSize + 12 + len(b.SampleSize)*4
+func (b *StszBox) Size() uint64 {
+	return b.boxSize(12 + uint64(len(b.SampleSize))*4)
 }
 
 func (b *StszBox) Dump() {
@@ -62,6 +69,7 @@ func (b *StszBox) Dump() {
 	}
 }
 
+// GetSampleSize returns the size of sample i (starting at 1)
 func (b *StszBox) GetSampleSize(i int) uint32 {
 	if i > len(b.SampleSize) {
 		return b.SampleUniformSize
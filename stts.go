@@ -19,6 +19,7 @@ import (
 //   * sample count : the number of consecutive samples having the same duration
 //   * time delta : duration in time units
 type SttsBox struct {
+	header
 	Version         byte
 	Flags           [3]byte
 	SampleCount     []uint32
@@ -30,6 +31,9 @@ func DecodeStts(r io.Reader) (Box, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(data) < 8 {
+		return nil, ErrBadFormat
+	}
 	b := &SttsBox{
 		Version:         data[0],
 		Flags:           [3]byte{data[1], data[2], data[3]},
@@ -37,6 +41,9 @@ func DecodeStts(r io.Reader) (Box, error) {
 		SampleTimeDelta: []uint32{},
 	}
 	ec := binary.BigEndian.Uint32(data[4:8])
+	if !hasEntries(data, 8, ec, 8) {
+		return nil, ErrBadFormat
+	}
 	for i := 0; i < int(ec); i++ {
 		s_count := binary.BigEndian.Uint32(data[(8 + 8*i):(12 + 8*i)])
 		s_delta := binary.BigEndian.Uint32(data[(12 + 8*i):(16 + 8*i)])
@@ -50,8 +57,8 @@ func (b *SttsBox) Type() string {
 	return "stts"
 }
 
-func (b *SttsBox) Size() int {
-	return BoxHeaderSize + 8 + len(b.SampleCount)*8
+func (b *SttsBox) Size() uint64 {
+	return b.boxSize(8 + uint64(len(b.SampleCount))*8)
 }
 
 func (b *SttsBox) GetTimeCode(sample, timescale uint32) time.Duration {
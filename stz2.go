@@ -0,0 +1,119 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Compact Sample Size Box (stz2 - mandatory if stsz is absent)
+//
+// Contained in : Sample Table box (stbl)
+//
+// Status: decoded
+//
+// This is the compact version of the sample size box (stsz). Sample sizes are stored using
+// FieldSize bits (4, 8 or 16) per sample.
+type Stz2Box struct {
+	header
+	Version    byte
+	Flags      [3]byte
+	FieldSize  byte
+	SampleSize []uint32
+}
+
+func DecodeStz2(r io.Reader) (Box, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 {
+		return nil, ErrBadFormat
+	}
+	b := &Stz2Box{
+		Version:    data[0],
+		Flags:      [3]byte{data[1], data[2], data[3]},
+		FieldSize:  data[7],
+		SampleSize: []uint32{},
+	}
+	sc := binary.BigEndian.Uint32(data[8:12])
+	if b.FieldSize != 4 && b.FieldSize != 8 && b.FieldSize != 16 {
+		return nil, ErrBadFormat
+	}
+	if uint64(len(data)) < 12+(uint64(sc)*uint64(b.FieldSize)+7)/8 {
+		return nil, ErrBadFormat
+	}
+	for i := 0; i < int(sc); i++ {
+		var sz uint32
+		switch b.FieldSize {
+		case 4:
+			sz = uint32(data[12+i/2])
+			if i%2 == 0 {
+				sz >>= 4
+			}
+			sz &= 0xf
+		case 8:
+			sz = uint32(data[12+i])
+		case 16:
+			sz = uint32(binary.BigEndian.Uint16(data[(12 + 2*i):(14 + 2*i)]))
+		}
+		b.SampleSize = append(b.SampleSize, sz)
+	}
+	return b, nil
+}
+
+func (b *Stz2Box) Type() string {
+	return "stz2"
+}
+
+func (b *Stz2Box) Size() uint64 {
+	return b.boxSize(12 + (uint64(len(b.SampleSize))*uint64(b.FieldSize)+7)/8)
+}
+
+func (b *Stz2Box) Dump() {
+	fmt.Printf("Samples : %d total samples (%d bits sizes)\n", len(b.SampleSize), b.FieldSize)
+}
+
+// GetSampleSize returns the size of sample i (starting at 1)
+func (b *Stz2Box) GetSampleSize(i int) uint32 {
+	return b.SampleSize[i-1]
+}
+
+// fits returns true if all sizes can be stored using the box field size
+func (b *Stz2Box) fits(sizes []uint32) bool {
+	for _, sz := range sizes {
+		if sz >= 1<<b.FieldSize {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *Stz2Box) Encode(w io.Writer) error {
+	err := EncodeHeader(b, w)
+	if err != nil {
+		return err
+	}
+	buf := makebuf(b)
+	buf[0] = b.Version
+	buf[1], buf[2], buf[3] = b.Flags[0], b.Flags[1], b.Flags[2]
+	buf[7] = b.FieldSize
+	binary.BigEndian.PutUint32(buf[8:], uint32(len(b.SampleSize)))
+	for i, sz := range b.SampleSize {
+		switch b.FieldSize {
+		case 4:
+			if i%2 == 0 {
+				buf[12+i/2] = byte(sz&0xf) << 4
+			} else {
+				buf[12+i/2] |= byte(sz & 0xf)
+			}
+		case 8:
+			buf[12+i] = byte(sz)
+		case 16:
+			binary.BigEndian.PutUint16(buf[12+2*i:], uint16(sz))
+		}
+	}
+	_, err = w.Write(buf)
+	return err
+}
@@ -5,11 +5,12 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 )
 
 // Track Header Box (tkhd - mandatory)
 //
-// Status : vonly ersion 0 is decoded. version 1 is not supported
+// Status : decoded (versions 0 and 1)
 //
 // This box describes the track. Duration is measured in time units (according to the time scale
 // defined in the movie header box).
@@ -17,13 +18,16 @@ import (
 // Volume (relevant for audio tracks) is a fixed point number (8 bits + 8 bits). Full volume is 1.0.
 // Width and Height (relevant for video tracks) are fixed point numbers (16 bits + 16 bits).
 // Video pixels are not necessarily square.
+//
+// As in mvhd, times and Duration are 64 bits wide in version 1, used when one of them exceeds 32 bits.
 type TkhdBox struct {
+	header
 	Version          byte
 	Flags            [3]byte
-	CreationTime     uint32
-	ModificationTime uint32
+	CreationTime     uint64
+	ModificationTime uint64
 	TrackId          uint32
-	Duration         uint32
+	Duration         uint64
 	Layer            uint16
 	AlternateGroup   uint16 // should be int16
 	Volume           Fixed16
@@ -36,28 +40,57 @@ func DecodeTkhd(r io.Reader) (Box, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &TkhdBox{
-		Version:          data[0],
-		Flags:            [3]byte{data[1], data[2], data[3]},
-		CreationTime:     binary.BigEndian.Uint32(data[4:8]),
-		ModificationTime: binary.BigEndian.Uint32(data[8:12]),
-		TrackId:          binary.BigEndian.Uint32(data[12:16]),
-		Volume:           fixed16(data[36:38]),
-		Duration:         binary.BigEndian.Uint32(data[20:24]),
-		Layer:            binary.BigEndian.Uint16(data[32:34]),
-		AlternateGroup:   binary.BigEndian.Uint16(data[34:36]),
-		Matrix:           data[40:76],
-		Width:            fixed32(data[76:80]),
-		Height:           fixed32(data[80:84]),
-	}, nil
+	if len(data) < 4 {
+		return nil, ErrBadFormat
+	}
+	b := &TkhdBox{
+		Version: data[0],
+		Flags:   [3]byte{data[1], data[2], data[3]},
+	}
+	var off int
+	if b.Version == 1 {
+		if len(data) < 96 {
+			return nil, ErrBadFormat
+		}
+		b.CreationTime = binary.BigEndian.Uint64(data[4:12])
+		b.ModificationTime = binary.BigEndian.Uint64(data[12:20])
+		b.TrackId = binary.BigEndian.Uint32(data[20:24])
+		b.Duration = binary.BigEndian.Uint64(data[28:36])
+		off = 12
+	} else {
+		if len(data) < 84 {
+			return nil, ErrBadFormat
+		}
+		b.CreationTime = uint64(binary.BigEndian.Uint32(data[4:8]))
+		b.ModificationTime = uint64(binary.BigEndian.Uint32(data[8:12]))
+		b.TrackId = binary.BigEndian.Uint32(data[12:16])
+		b.Duration = uint64(binary.BigEndian.Uint32(data[20:24]))
+	}
+	b.Layer = binary.BigEndian.Uint16(data[off+32 : off+34])
+	b.AlternateGroup = binary.BigEndian.Uint16(data[off+34 : off+36])
+	b.Volume = fixed16(data[off+36 : off+38])
+	b.Matrix = data[off+40 : off+76]
+	b.Width = fixed32(data[off+76 : off+80])
+	b.Height = fixed32(data[off+80 : off+84])
+	return b, nil
 }
 
 func (b *TkhdBox) Type() string {
 	return "tkhd"
 }
 
-func (b *TkhdBox) Size() int {
-	return BoxHeaderSize + 84
+func (b *TkhdBox) version() byte {
+	if b.Version == 1 || b.CreationTime > math.MaxUint32 || b.ModificationTime > math.MaxUint32 || b.Duration > math.MaxUint32 {
+		return 1
+	}
+	return 0
+}
+
+func (b *TkhdBox) Size() uint64 {
+	if b.version() == 1 {
+		return b.boxSize(96)
+	}
+	return b.boxSize(84)
 }
 
 func (b *TkhdBox) Encode(w io.Writer) error {
@@ -66,18 +99,27 @@ func (b *TkhdBox) Encode(w io.Writer) error {
 		return err
 	}
 	buf := makebuf(b)
-	buf[0] = b.Version
+	buf[0] = b.version()
 	buf[1], buf[2], buf[3] = b.Flags[0], b.Flags[1], b.Flags[2]
-	binary.BigEndian.PutUint32(buf[4:], b.CreationTime)
-	binary.BigEndian.PutUint32(buf[8:], b.ModificationTime)
-	binary.BigEndian.PutUint32(buf[12:], b.TrackId)
-	binary.BigEndian.PutUint32(buf[20:], b.Duration)
-	binary.BigEndian.PutUint16(buf[32:], b.Layer)
-	binary.BigEndian.PutUint16(buf[34:], b.AlternateGroup)
-	putFixed16(buf[36:], b.Volume)
-	copy(buf[40:], b.Matrix)
-	putFixed32(buf[76:], b.Width)
-	putFixed32(buf[80:], b.Height)
+	var off int
+	if buf[0] == 1 {
+		binary.BigEndian.PutUint64(buf[4:], b.CreationTime)
+		binary.BigEndian.PutUint64(buf[12:], b.ModificationTime)
+		binary.BigEndian.PutUint32(buf[20:], b.TrackId)
+		binary.BigEndian.PutUint64(buf[28:], b.Duration)
+		off = 12
+	} else {
+		binary.BigEndian.PutUint32(buf[4:], uint32(b.CreationTime))
+		binary.BigEndian.PutUint32(buf[8:], uint32(b.ModificationTime))
+		binary.BigEndian.PutUint32(buf[12:], b.TrackId)
+		binary.BigEndian.PutUint32(buf[20:], uint32(b.Duration))
+	}
+	binary.BigEndian.PutUint16(buf[off+32:], b.Layer)
+	binary.BigEndian.PutUint16(buf[off+34:], b.AlternateGroup)
+	putFixed16(buf[off+36:], b.Volume)
+	copy(buf[off+40:], b.Matrix)
+	putFixed32(buf[off+76:], b.Width)
+	putFixed32(buf[off+80:], b.Height)
 	_, err = w.Write(buf)
 	return err
 }
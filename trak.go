@@ -1,16 +1,24 @@
 package mp4
 
-import "io"
+import (
+	"io"
+	"time"
+)
 
 // Track Box (tkhd - mandatory)
 //
 // Contained in : Movie Box (moov)
 //
 // A media file can contain one or more tracks.
+//
+// Boxes other than tkhd, mdia and edts (tref, udta, ...) are stored in Other.
 type TrakBox struct {
-	Tkhd *TkhdBox
-	Mdia *MdiaBox
-	Edts *EdtsBox
+	header
+	Tkhd  *TkhdBox
+	Mdia  *MdiaBox
+	Edts  *EdtsBox
+	Other []Box
+	order []string
 }
 
 func DecodeTrak(r io.Reader) (Box, error) {
@@ -18,17 +26,17 @@ func DecodeTrak(r io.Reader) (Box, error) {
 	if err != nil {
 		return nil, err
 	}
-	t := &TrakBox{}
+	t := &TrakBox{order: boxTypes(l)}
 	for _, b := range l {
-		switch b.Type() {
-		case "tkhd":
-			t.Tkhd = b.(*TkhdBox)
-		case "mdia":
-			t.Mdia = b.(*MdiaBox)
-		case "edts":
-			t.Edts = b.(*EdtsBox)
+		switch c := b.(type) {
+		case *TkhdBox:
+			t.Tkhd = c
+		case *MdiaBox:
+			t.Mdia = c
+		case *EdtsBox:
+			t.Edts = c
 		default:
-			return nil, ErrBadFormat
+			t.Other = append(t.Other, b)
 		}
 	}
 	return t, nil
@@ -38,13 +46,23 @@ func (b *TrakBox) Type() string {
 	return "trak"
 }
 
-func (b *TrakBox) Size() int {
-	sz := b.Tkhd.Size()
-	sz += b.Mdia.Size()
+// children returns the child boxes, in the default encoding order
+func (b *TrakBox) children() []Box {
+	l := []Box{}
+	if b.Tkhd != nil {
+		l = append(l, b.Tkhd)
+	}
 	if b.Edts != nil {
-		sz += b.Edts.Size()
+		l = append(l, b.Edts)
+	}
+	if b.Mdia != nil {
+		l = append(l, b.Mdia)
 	}
-	return sz + BoxHeaderSize
+	return append(l, b.Other...)
+}
+
+func (b *TrakBox) Size() uint64 {
+	return b.boxSize(childrenSize(b.children()))
 }
 
 func (b *TrakBox) Dump() {
@@ -60,15 +78,64 @@ func (b *TrakBox) Encode(w io.Writer) error {
 	if err != nil {
 		return err
 	}
-	err = b.Tkhd.Encode(w)
-	if err != nil {
-		return err
+	return encodeOrdered(w, b.order, b.children())
+}
+
+// PresentationTime converts a media time (in media timescale units, see mdhd) to a time on the presentation
+// timeline, using the edit list if present. movieTimescale is the timescale of the movie header (mvhd), used
+// for edit durations.
+//
+// ok is false if the media time is not presented (outside all edits).
+func (b *TrakBox) PresentationTime(mediaTime int64, movieTimescale uint32) (t time.Duration, ok bool) {
+	timescale := b.Mdia.Mdhd.Timescale
+	if b.Edts == nil || b.Edts.Elst == nil || len(b.Edts.Elst.SegmentDuration) == 0 {
+		return unitsToDuration(mediaTime, timescale), mediaTime >= 0
 	}
-	if b.Edts != nil {
-		err = b.Edts.Encode(w)
-		if err != nil {
-			return err
+	elst := b.Edts.Elst
+	var start time.Duration
+	for i, sd := range elst.SegmentDuration {
+		mt := elst.MediaTime[i]
+		switch {
+		case mt == -1:
+		case elst.MediaRateInteger[i] == 0:
+			if mediaTime == mt {
+				return start, true
+			}
+		case mediaTime >= mt:
+			// a segment duration of 0 extends to the end of the media
+			if sd == 0 || unitsToDuration(mediaTime-mt, timescale) < unitsToDuration(int64(sd), movieTimescale) {
+				return start + unitsToDuration(mediaTime-mt, timescale), true
+			}
+		}
+		start += unitsToDuration(int64(sd), movieTimescale)
+	}
+	return 0, false
+}
+
+// MediaTime converts a time on the presentation timeline to a media time (in media timescale units, see mdhd),
+// using the edit list if present. movieTimescale is the timescale of the movie header (mvhd), used
+// for edit durations.
+//
+// ok is false if no media is presented at that time (empty edit, or after the last edit).
+func (b *TrakBox) MediaTime(t time.Duration, movieTimescale uint32) (mediaTime int64, ok bool) {
+	timescale := b.Mdia.Mdhd.Timescale
+	if b.Edts == nil || b.Edts.Elst == nil || len(b.Edts.Elst.SegmentDuration) == 0 {
+		return durationToUnits(t, timescale), t >= 0
+	}
+	elst := b.Edts.Elst
+	var start time.Duration
+	for i, sd := range elst.SegmentDuration {
+		d := unitsToDuration(int64(sd), movieTimescale)
+		if t >= start && (t < start+d || sd == 0) {
+			switch {
+			case elst.MediaTime[i] == -1:
+				return 0, false
+			case elst.MediaRateInteger[i] == 0:
+				return elst.MediaTime[i], true
+			}
+			return elst.MediaTime[i] + durationToUnits(t-start, timescale), true
 		}
+		start += d
 	}
-	return b.Mdia.Encode(w)
+	return 0, false
 }
@@ -0,0 +1,104 @@
+package mp4
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestElstVersion(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		duration uint64
+		time     int64
+		version  byte
+	}{
+		{"32 bits", math.MaxUint32, math.MaxInt32, 0},
+		{"empty edit", 1000, -1, 0},
+		{"negative media time", 1000, math.MinInt32, 0},
+		{"64 bits duration", math.MaxUint32 + 1, 0, 1},
+		{"64 bits media time", 1000, math.MaxInt32 + 1, 1},
+		{"64 bits negative media time", 1000, math.MinInt32 - 1, 1},
+	} {
+		b := &ElstBox{
+			SegmentDuration:   []uint64{500, c.duration},
+			MediaTime:         []int64{-1, c.time},
+			MediaRateInteger:  []int16{1, -1},
+			MediaRateFraction: []int16{0, 0x4000},
+		}
+		size := uint64(BoxHeaderSize + 8 + 2*12)
+		if c.version == 1 {
+			size = BoxHeaderSize + 8 + 2*20
+		}
+		if b.Size() != size {
+			t.Fatalf("%s : size %d, expected %d", c.name, b.Size(), size)
+		}
+		d := reencode(t, b).(*ElstBox)
+		if d.Version != c.version {
+			t.Fatalf("%s : decoded version %d, expected %d", c.name, d.Version, c.version)
+		}
+		if !reflect.DeepEqual(d.SegmentDuration, b.SegmentDuration) || !reflect.DeepEqual(d.MediaTime, b.MediaTime) ||
+			!reflect.DeepEqual(d.MediaRateInteger, b.MediaRateInteger) || !reflect.DeepEqual(d.MediaRateFraction, b.MediaRateFraction) {
+			t.Fatalf("%s : decoded entries differ %v %v %v %v", c.name, d.SegmentDuration, d.MediaTime, d.MediaRateInteger, d.MediaRateFraction)
+		}
+	}
+}
+
+// The video track of moov_first.mp4 has a timescale of 12800 (movie timescale 1000), and is 4s long
+func TestEditTimes(t *testing.T) {
+	data := readFile(t, "moov_first.mp4")
+	m, err := DecodeAt(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := m.Moov.Trak[0]
+	ms := time.Millisecond
+	// 500ms empty edit (delay), then 3s starting at 1s in the media
+	tr.Edts.Elst = &ElstBox{
+		SegmentDuration:   []uint64{500, 3000},
+		MediaTime:         []int64{-1, 12800},
+		MediaRateInteger:  []int16{1, 1},
+		MediaRateFraction: []int16{0, 0},
+	}
+	for _, c := range []struct {
+		t    time.Duration
+		mt   int64
+		ok   bool
+		back bool // PresentationTime(mt) returns t
+	}{
+		{0, 0, false, false},
+		{499 * ms, 0, false, false},
+		{500 * ms, 12800, true, true},
+		{1500 * ms, 25600, true, true},
+		{3499 * ms, 51187, true, false},
+		{3500 * ms, 0, false, false},
+	} {
+		mt, ok := tr.MediaTime(c.t, m.Moov.Mvhd.Timescale)
+		if mt != c.mt || ok != c.ok {
+			t.Errorf("media time at %s : %d (%v), expected %d (%v)", c.t, mt, ok, c.mt, c.ok)
+		}
+		if !c.back {
+			continue
+		}
+		if pt, ok := tr.PresentationTime(mt, m.Moov.Mvhd.Timescale); !ok || pt != c.t {
+			t.Errorf("presentation time of %d : %s (%v), expected %s", mt, pt, ok, c.t)
+		}
+	}
+	// media times before and after the media edit are not presented
+	for _, mt := range []int64{0, 12799, 12800 + 3*12800} {
+		if pt, ok := tr.PresentationTime(mt, m.Moov.Mvhd.Timescale); ok {
+			t.Errorf("media time %d presented at %s", mt, pt)
+		}
+	}
+
+	// without edit list, times are the same on both timelines
+	tr.Edts = nil
+	if mt, ok := tr.MediaTime(time.Second, m.Moov.Mvhd.Timescale); !ok || mt != 12800 {
+		t.Errorf("media time at 1s : %d (%v)", mt, ok)
+	}
+	if pt, ok := tr.PresentationTime(12800, m.Moov.Mvhd.Timescale); !ok || pt != time.Second {
+		t.Errorf("presentation time of 12800 : %s (%v)", pt, ok)
+	}
+}
@@ -5,8 +5,13 @@ import "io"
 // User Data Box (udta - optional)
 //
 // Contained in: Movie Box (moov) or Track Box (trak)
+//
+// Boxes other than meta (cprt, ...) are stored in Other.
 type UdtaBox struct {
-	Meta *MetaBox
+	header
+	Meta  *MetaBox
+	Other []Box
+	order []string
 }
 
 func DecodeUdta(r io.Reader) (Box, error) {
@@ -14,13 +19,13 @@ func DecodeUdta(r io.Reader) (Box, error) {
 	if err != nil {
 		return nil, err
 	}
-	u := &UdtaBox{}
+	u := &UdtaBox{order: boxTypes(l)}
 	for _, b := range l {
-		switch b.Type() {
-		case "meta":
-			u.Meta = b.(*MetaBox)
+		switch c := b.(type) {
+		case *MetaBox:
+			u.Meta = c
 		default:
-			return nil, ErrBadFormat
+			u.Other = append(u.Other, b)
 		}
 	}
 	return u, nil
@@ -30,8 +35,17 @@ func (b *UdtaBox) Type() string {
 	return "udta"
 }
 
-func (b *UdtaBox) Size() int {
-	return BoxHeaderSize + b.Meta.Size()
+// children returns the child boxes, in the default encoding order
+func (b *UdtaBox) children() []Box {
+	l := []Box{}
+	if b.Meta != nil {
+		l = append(l, b.Meta)
+	}
+	return append(l, b.Other...)
+}
+
+func (b *UdtaBox) Size() uint64 {
+	return b.boxSize(childrenSize(b.children()))
 }
 
 func (b *UdtaBox) Encode(w io.Writer) error {
@@ -39,5 +53,5 @@ func (b *UdtaBox) Encode(w io.Writer) error {
 	if err != nil {
 		return err
 	}
-	return b.Meta.Encode(w)
+	return encodeOrdered(w, b.order, b.children())
 }
@@ -12,6 +12,7 @@ import (
 //
 // Status: decoded
 type VmhdBox struct {
+	header
 	Version      byte
 	Flags        [3]byte
 	GraphicsMode uint16
@@ -23,6 +24,9 @@ func DecodeVmhd(r io.Reader) (Box, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(data) < 12 {
+		return nil, ErrBadFormat
+	}
 	b := &VmhdBox{
 		Version:      data[0],
 		Flags:        [3]byte{data[1], data[2], data[3]},
@@ -38,8 +42,8 @@ func (b *VmhdBox) Type() string {
 	return "vmhd"
 }
 
-func (b *VmhdBox) Size() int {
-	return BoxHeaderSize + 12
+func (b *VmhdBox) Size() uint64 {
+	return b.boxSize(12)
 }
 
 func (b *VmhdBox) Encode(w io.Writer) error {